@@ -1,25 +1,53 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"ip-proxy-checker/internal/checker"
+	"ip-proxy-checker/internal/export"
+	"ip-proxy-checker/internal/gateway"
+	"ip-proxy-checker/internal/leases"
 	"ip-proxy-checker/internal/models"
 	"ip-proxy-checker/internal/parser"
 	"ip-proxy-checker/internal/proxy"
 	"ip-proxy-checker/internal/storage"
+	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
 type App struct {
-	config *storage.Config
-	cache  *storage.Cache
-	logger zerolog.Logger
+	config     *storage.Config
+	cache      *storage.Cache
+	providers  *checker.Providers
+	retry      checker.RetryPolicy
+	classifier *checker.Classifier
+	scheduler  *checker.Scheduler
+	aimd       *checker.AIMD
+	jobs       *checker.Jobs
+	imports    *checker.Imports
+	subs       *checker.Subscriptions
+	dials      *checker.DialLimiter
+	gateway    *gateway.Gateway
+	leases     *leases.Pool
+	inventory  *storage.Inventory
+	logger     zerolog.Logger
 }
 
 func NewApp() *App {
@@ -40,79 +68,538 @@ func (a *App) Init() error {
 		return err
 	}
 	a.cache = cache
+	inventory, err := storage.NewInventory(a.config.Storage.InventoryPath, a.config.Storage.HistorySize)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Failed to open proxy inventory")
+		return err
+	}
+	a.inventory = inventory
+	a.providers = checker.NewProviders(a.config, a.cache)
+	a.retry = checker.NewRetryPolicy(a.config)
+	a.scheduler = checker.NewScheduler(a.config.Scheduler.MaxConcurrency, a.config.Scheduler.PriorityWeights, a.config.Scheduler.TenantWeights)
+	if cfg, ok := checker.NewAIMDConfig(a.config); ok {
+		// One controller for every job, so concurrent checks share what it learns
+		cfg.Max = min(cfg.Max, a.config.Scheduler.MaxConcurrency)
+		a.aimd = checker.NewAIMD(cfg)
+		a.scheduler.Adapt(a.aimd)
+	}
+	a.jobs = checker.NewJobs(a.config.Jobs.Retention)
+	a.imports = checker.NewImports(a.config.Parser.ImportTTL)
+	a.subs = checker.NewSubscriptions(context.Background(), a.config, a.checkSubscription)
+	a.dials = checker.NewDialLimiter(checker.NewDialLimits(a.config))
+	a.leases = leases.New(a.config, a.checkedPool, a.recheckProxies)
+
+	a.classifier = checker.NewClassifier()
+	a.classifier.ResolveRDNS = a.config.Classifier.RDNSLookup
+	if path := a.config.Classifier.ASNFile; path != "" {
+		if n, err := a.classifier.LoadASNFile(path); err != nil {
+			a.logger.Warn().Err(err).Str("path", path).Msg("Failed to load ASN classification file")
+		} else {
+			a.logger.Info().Int("entries", n).Msg("ASN classification loaded")
+		}
+	}
+	if path := a.config.Classifier.CloudRangesFile; path != "" {
+		if n, err := a.classifier.LoadCloudRangesFile(path); err != nil {
+			a.logger.Warn().Err(err).Str("path", path).Msg("Failed to load cloud ranges file")
+		} else {
+			a.logger.Info().Int("ranges", n).Msg("Cloud ranges loaded")
+		}
+	}
+
+	// Background work starts last: subscription fetches, rechecks and the
+	// gateway run checks that need every dependency above
+	if a.config.Storage.CacheEnabled && a.config.Storage.VacuumInterval > 0 {
+		go a.vacuumCache(a.config.Storage.VacuumInterval)
+	}
+	for _, src := range a.config.Subscriptions.Sources {
+		if _, err := a.subs.Add(src, "config"); err != nil {
+			a.logger.Warn().Err(err).Str("subscription", src.Name).Msg("Skipping subscription")
+		}
+	}
+	go a.leases.Run(context.Background())
+	if a.config.Gateway.Enabled {
+		a.startGateway()
+	}
 	return nil
 }
 
+// startGateway serves the local rotating proxy over the checked pool. A
+// gateway that cannot start is logged and left off.
+func (a *App) startGateway() {
+	gw, err := gateway.New(a.config, a.checkedPool)
+	if err == nil {
+		err = gw.Start(context.Background())
+	}
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Failed to start proxy gateway")
+		return
+	}
+	a.gateway = gw
+	a.logger.Info().Str("http", a.config.Gateway.HTTPListen).Str("socks", a.config.Gateway.SOCKSListen).Str("strategy", a.config.Gateway.Strategy).Msg("Proxy gateway listening")
+}
+
+// vacuumCache periodically removes expired cache rows.
+func (a *App) vacuumCache(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		deleted, err := a.cache.Vacuum()
+		if err != nil {
+			a.logger.Error().Err(err).Msg("Cache vacuum failed")
+			continue
+		}
+		a.logger.Debug().Int64("deleted", deleted).Msg("Cache vacuumed")
+	}
+}
+
+// lookupOptions converts the cache override fields of a check request.
+// maxAge accepts a Go duration ("6h") or a number of seconds.
+func lookupOptions(maxAge string, noCache bool) (checker.LookupOptions, error) {
+	d, err := durationOrSeconds(maxAge)
+	if err != nil {
+		return checker.LookupOptions{}, fmt.Errorf("invalid max_age %q", maxAge)
+	}
+	return checker.LookupOptions{NoCache: noCache, MaxAge: d}, nil
+}
+
+// durationOrSeconds parses a Go duration ("6h") or a number of seconds; empty is 0.
+func durationOrSeconds(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	secs, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(secs) * time.Second, nil
+}
+
 func (a *App) HandleParseInput(w http.ResponseWriter, r *http.Request) {
 	var body struct {
 		Input string `json:"input"`
+		parser.ParseOptions
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	ips := parser.ParseIPList(body.Input)
-	proxies := parser.ParseProxyList(body.Input)
+	report := parser.Parse(body.Input, body.ParseOptions)
+	a.logger.Info().Int("accepted", report.Total).Int("rejected", len(report.Rejected)).Int("duplicates", len(report.Duplicates)).Msg("Input parsed")
 
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"ips":     ips,
-		"proxies": proxies,
-		"total":   len(ips) + len(proxies),
-	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// HandleImportFile streams an uploaded list through the parser and keeps the
+// accepted entries under an import ID that check requests can refer to.
+// Options come from the query string or from form fields sent before the file.
+func (a *App) HandleImportFile(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(a.config.Parser.MaxUploadMB)<<20)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	form := r.URL.Query()
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			http.Error(w, "no file in the upload", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), uploadErrorStatus(err))
+			return
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, 4<<10))
+			if err != nil {
+				http.Error(w, err.Error(), uploadErrorStatus(err))
+				return
+			}
+			form.Set(part.FormName(), string(value))
+			continue
+		}
+
+		opts, err := fileOptions(form, part.FileName())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report, err := parser.ParseFile(part, opts)
+		if err != nil {
+			http.Error(w, err.Error(), uploadErrorStatus(err))
+			return
+		}
+
+		ips := make([]string, 0, len(report.IPs))
+		for _, ip := range report.IPs {
+			ips = append(ips, ip.IP)
+		}
+		imp := a.imports.Add(part.FileName(), ips, report.Proxies)
+		a.logger.Info().Str("import_id", imp.ID).Str("file", imp.Name).Str("format", report.File.Format).Int("accepted", report.Total).Int("rejected", len(report.Rejected)).Msg("List imported")
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			ImportID string `json:"import_id"`
+			parser.ParseReport
+		}{imp.ID, report})
+		return
+	}
+}
+
+// fileOptions reads the parse options of an upload from its form values.
+func fileOptions(form url.Values, name string) (parser.FileOptions, error) {
+	opts := parser.FileOptions{
+		Name:      name,
+		Format:    form.Get("format"),
+		Delimiter: form.Get("delimiter"),
+		Header:    form.Get("header"),
+		Columns: parser.Columns{
+			Host:     form.Get("host_column"),
+			Port:     form.Get("port_column"),
+			User:     form.Get("user_column"),
+			Pass:     form.Get("pass_column"),
+			Protocol: form.Get("protocol_column"),
+		},
+	}
+	for key, dst := range map[string]*bool{"dedupe": &opts.Dedupe, "sort": &opts.Sort, "extract": &opts.Extract} {
+		if v := form.Get(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return opts, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*dst = b
+		}
+	}
+	return opts, nil
+}
+
+func uploadErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// importedList appends the entries of an upload to a check request.
+func importedList[T any](a *App, w http.ResponseWriter, id string, list []T, entries func(*checker.Import) []T) ([]T, bool) {
+	if id == "" {
+		return list, true
+	}
+	imp, ok := a.imports.Get(id)
+	if !ok {
+		http.Error(w, fmt.Sprintf("import %q not found or expired", id), http.StatusNotFound)
+		return nil, false
+	}
+	return append(list, entries(imp)...), true
 }
 
 func (a *App) HandleCheckWhois(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		IPs []string `json:"ips"`
+		IPs      []string `json:"ips"`
+		ImportID string   `json:"import_id"`
+		MaxAge   string   `json:"max_age"`
+		NoCache  bool     `json:"no_cache"`
+		Summary  bool     `json:"summary"`
+		Priority string   `json:"priority"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	opts, err := lookupOptions(body.MaxAge, body.NoCache)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	adm, err := a.admission(r, "", body.Priority)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var ok bool
+	if body.IPs, ok = importedList(a, w, body.ImportID, body.IPs, func(imp *checker.Import) []string { return imp.IPs }); !ok {
+		return
+	}
+	opts.Stats = &checker.LookupStats{}
 
-	a.logger.Info().Int("count", len(body.IPs)).Msg("Starting Whois check")
-	results := make([]models.WhoisResult, 0)
-	wp := checker.NewWorkerPool(a.config.Worker.PoolSize)
+	ctx := r.Context()
+	job, run := a.startWhoisCheck(ctx, adm, body.IPs, opts)
+	w.Header().Set("X-Job-ID", job.ID)
+	results := run()
 
-	wp.Start(func(job checker.Job) interface{} {
-		ip := job.Data.(string)
-		res, err := checker.CheckIPWho(ip)
-		if err != nil {
-			a.logger.Error().Err(err).Str("ip", ip).Msg("Whois check failed")
-			return models.WhoisResult{IP: ip, Status: "failed", Error: err.Error()}
+	if ctx.Err() != nil {
+		a.logger.Warn().Err(ctx.Err()).Int("completed", len(results)).Msg("Whois check cancelled")
+		return
+	}
+
+	summary := a.providers.Summary(opts.Stats, len(results))
+	summary.Concurrency = job.Concurrency()
+	a.logger.Info().Int("count", summary.Total).Int64("calls_saved", summary.CallsSaved).Int64("cache_hits", summary.CacheHits).Int("concurrency", summary.Concurrency).Msg("Whois check finished")
+	writeCheckResponse(w, results, summary, body.Summary)
+}
+
+// startWhoisCheck expands the IP tokens and registers their check job; run
+// looks every address up and returns the results in input order.
+func (a *App) startWhoisCheck(ctx context.Context, adm checkAdmission, tokens []string, opts checker.LookupOptions) (*checker.Job, func() []models.WhoisResult) {
+	targets := a.expandWhoisTargets(ctx, tokens)
+	a.logger.Info().Int("tokens", len(tokens)).Int("count", len(targets)).Str("tenant", adm.tenant).Msg("Starting Whois check")
+	pool, job := newCheckPool(a, "whois", adm, len(targets), func(ctx context.Context, t whoisTarget) models.WhoisResult {
+		if t.err != "" {
+			return models.WhoisResult{IP: t.Source, Status: "failed", Error: t.err, Source: t.Source}
+		}
+		var res models.WhoisResult
+		if t.Category == parser.CategoryPublic {
+			res = a.checkWhois(ctx, t.IP, opts)
+		} else {
+			// Providers know nothing about bogons; do not spend quota on them
+			special := parser.ClassifyAddr(netip.MustParseAddr(t.IP))
+			res = models.WhoisResult{IP: t.IP, Status: "non_routable", Error: special.Name + ", not looked up"}
+		}
+		res.Category = t.Category
+		if t.Type != parser.IPTypeSimple {
+			res.Source = t.Source
 		}
+		return res
+	}, func(res models.WhoisResult) error {
 		if res.Status == "failed" {
-			a.logger.Warn().Str("ip", ip).Str("error", res.Error).Msg("Whois API returned failure")
+			return errors.New(res.Error)
 		}
-		return *res
+		return nil
 	})
 
-	for i, ip := range body.IPs {
-		wp.AddJob(checker.Job{ID: i, Type: "whois", Data: ip})
+	return job, func() []models.WhoisResult {
+		results := make([]models.WhoisResult, 0, len(targets))
+		defer func() { a.jobs.Finish(job, results) }()
+		for res := range pool.StreamOrdered(ctx, slices.Values(targets)) {
+			if res.Err != nil {
+				ip := targets[res.Index].IP
+				a.logger.Error().Err(res.Err).Str("ip", ip).Msg("Whois check crashed")
+				res.Value = models.WhoisResult{IP: ip, Status: "failed", Error: res.Err.Error()}
+			}
+			results = append(results, res.Value)
+			job.Advance()
+		}
+		return results
+	}
+}
+
+// checkSubscription starts scheduled-priority checks over the entries a
+// subscription fetch added: a whois check for IPs, a quality check for proxies.
+func (a *App) checkSubscription(ctx context.Context, name string, ips []string, proxies []models.ProxyInput) []string {
+	adm := checkAdmission{tenant: "subscription:" + name, priority: checker.PriorityScheduled}
+	a.logger.Info().Str("subscription", name).Int("ips", len(ips)).Int("proxies", len(proxies)).Msg("New subscription entries")
+
+	var jobs []string
+	if len(ips) > 0 {
+		job, run := a.startWhoisCheck(ctx, adm, ips, checker.LookupOptions{})
+		jobs = append(jobs, job.ID)
+		go func() {
+			results := run()
+			failed := 0
+			for _, res := range results {
+				if res.Status == "failed" {
+					failed++
+				}
+			}
+			a.logger.Info().Str("subscription", name).Str("job", job.ID).Int("count", len(results)).Int("failed", failed).Msg("Subscription whois check finished")
+		}()
+	}
+	if len(proxies) > 0 {
+		for i := range proxies {
+			proxies[i].Tags = append(slices.Clip(proxies[i].Tags), name)
+		}
+		job, run := a.startQualityCheck(ctx, adm, inputTargets(proxies), a.config.API.IPQuality.APIKey, checker.LookupOptions{})
+		jobs = append(jobs, job.ID)
+		go func() {
+			results := run()
+			live := 0
+			for _, res := range results {
+				if res.Status == "Live" {
+					live++
+				}
+			}
+			a.logger.Info().Str("subscription", name).Str("job", job.ID).Int("count", len(results)).Int("live", live).Msg("Subscription quality check finished")
+		}()
+	}
+	return jobs
+}
+
+// recheckProxies runs a scheduled quality check of leased proxies reported
+// bad. Its results replace theirs in the checked pool.
+func (a *App) recheckProxies(ctx context.Context, proxies []models.ProxyInput) {
+	adm := checkAdmission{tenant: "pool", priority: checker.PriorityScheduled}
+	job, run := a.startQualityCheck(ctx, adm, inputTargets(proxies), a.config.API.IPQuality.APIKey, checker.LookupOptions{})
+	results := run()
+	live := 0
+	for _, res := range results {
+		if res.Status == "Live" {
+			live++
+		}
+	}
+	a.logger.Info().Str("job", job.ID).Int("count", len(results)).Int("live", live).Msg("Recheck of bad proxies finished")
+}
+
+// whoisTarget is one address to look up, or an input token that could not be expanded.
+type whoisTarget struct {
+	models.IPInput
+	err string
+}
+
+// expandWhoisTargets expands CIDRs, ranges and hostnames in the requested IPs,
+// keeping rejected tokens in input order so they are reported as failed results.
+// Every hostname gets its own per-IP deadline to resolve.
+func (a *App) expandWhoisTargets(ctx context.Context, tokens []string) []whoisTarget {
+	ips, rejected := parser.ExpandIPs(ctx, tokens, parser.ExpandOptions{
+		MaxExpansion:   a.config.Parser.MaxExpansion,
+		ResolveTimeout: a.config.Deadlines.PerIP,
+	})
+
+	targets := make([]whoisTarget, 0, len(ips)+len(rejected))
+	for _, ip := range ips {
+		for len(rejected) > 0 && rejected[0].Line < ip.Line {
+			targets = append(targets, whoisTarget{IPInput: models.IPInput{Source: rejected[0].Input}, err: rejected[0].Reason})
+			rejected = rejected[1:]
+		}
+		targets = append(targets, whoisTarget{IPInput: ip})
+	}
+	for _, r := range rejected {
+		targets = append(targets, whoisTarget{IPInput: models.IPInput{Source: r.Input}, err: r.Reason})
+	}
+	return targets
+}
+
+// checkAdmission identifies who a check runs for in the global scheduler.
+type checkAdmission struct {
+	tenant   string
+	priority string
+}
+
+// admission validates the priority of a check request and finds its tenant:
+// the X-User header, else the API key, else the client address.
+func (a *App) admission(r *http.Request, apiKey, priority string) (checkAdmission, error) {
+	switch priority {
+	case "":
+		priority = checker.PriorityInteractive
+	case checker.PriorityInteractive, checker.PriorityScheduled:
+	default:
+		return checkAdmission{}, fmt.Errorf("invalid priority %q: use %q or %q", priority, checker.PriorityInteractive, checker.PriorityScheduled)
+	}
+
+	var tenant string
+	if user := r.Header.Get("X-User"); user != "" {
+		tenant = "user:" + user
+	} else if key := cmp.Or(r.Header.Get("X-API-Key"), apiKey); key != "" {
+		// Never expose the key itself in the scheduler status
+		sum := sha256.Sum256([]byte(key))
+		tenant = "key:" + hex.EncodeToString(sum[:6])
+	} else {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		tenant = "ip:" + host
+	}
+	return checkAdmission{tenant: tenant, priority: priority}, nil
+}
+
+// newCheckPool registers a check job and builds its worker pool, gated by the
+// global scheduler. When worker.adaptive is enabled, outcome feeds the shared
+// AIMD controller that sizes the scheduler.
+func newCheckPool[In, Out any](a *App, kind string, adm checkAdmission, total int, fn func(context.Context, In) Out, outcome func(Out) error) (*checker.WorkerPool[In, Out], *checker.Job) {
+	size := a.config.Worker.PoolSize
+	if a.aimd != nil {
+		size = a.aimd.Max()
 	}
 
-	for i := 0; i < len(body.IPs); i++ {
-		res := <-wp.Results()
-		results = append(results, res.(models.WhoisResult))
+	pool := checker.NewWorkerPool(size, fn).Gate(func(ctx context.Context) (func(), error) {
+		return a.scheduler.Acquire(ctx, adm.tenant, adm.priority)
+	})
+	if a.aimd != nil {
+		pool.Adapt(a.aimd, outcome)
 	}
-	wp.Stop()
+	return pool, a.jobs.Start(kind, adm.tenant, adm.priority, total, size, a.aimd)
+}
 
-	json.NewEncoder(w).Encode(results)
+// checkWhois looks up one IP within the per-IP deadline.
+func (a *App) checkWhois(ctx context.Context, ip string, opts checker.LookupOptions) models.WhoisResult {
+	ctx, cancel := checker.WithBudget(ctx, a.config.Deadlines.PerIP)
+	defer cancel()
+
+	res, err := a.providers.Whois(ctx, ip, opts)
+	if err != nil {
+		a.logger.Error().Err(err).Str("ip", ip).Msg("Whois check failed")
+		failed := models.WhoisResult{IP: ip, Status: "failed", Error: err.Error()}
+		if res != nil {
+			failed.RetryInfo = res.RetryInfo
+		}
+		return failed
+	}
+	if res.Status == "failed" {
+		a.logger.Warn().Str("ip", ip).Str("error", res.Error).Msg("Whois API returned failure")
+	} else {
+		res.ConnectionClass = a.classifier.Classify(ctx, checker.ClassifyInput{
+			IP:  res.IP,
+			ASN: res.ASN,
+			ISP: res.ISP,
+		})
+	}
+	return *res
 }
 
 func (a *App) HandleCheckIPQuality(w http.ResponseWriter, r *http.Request) {
 	var body struct {
-		Proxies []string `json:"proxies"`
-		APIKey  string   `json:"api_key"`
+		Proxies  []string `json:"proxies"`
+		ImportID string   `json:"import_id"`
+		Tag      string   `json:"tag"` // every inventory proxy with this tag
+		APIKey   string   `json:"api_key"`
+		MaxAge   string   `json:"max_age"`
+		NoCache  bool     `json:"no_cache"`
+		Summary  bool     `json:"summary"`
+		Priority string   `json:"priority"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	opts, err := lookupOptions(body.MaxAge, body.NoCache)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	adm, err := a.admission(r, body.APIKey, body.Priority)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	targets := proxyTargets(body.Proxies)
+	var ok bool
+	if targets, ok = importedList(a, w, body.ImportID, targets, func(imp *checker.Import) []qualityTarget { return inputTargets(imp.Proxies) }); !ok {
+		return
+	}
+	if body.Tag != "" {
+		tagged, err := a.inventoryTargets(body.Tag)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(tagged) == 0 {
+			http.Error(w, fmt.Sprintf("no inventory proxy is tagged %q", body.Tag), http.StatusNotFound)
+			return
+		}
+		targets = append(targets, tagged...)
+	}
+	opts.Stats = &checker.LookupStats{}
 
 	a.logger.Debug().
-		Int("proxies_count", len(body.Proxies)).
+		Int("proxies_count", len(targets)).
 		Str("api_key_provided", func() string {
 			if body.APIKey == "" {
 				return "NO"
@@ -128,152 +615,372 @@ func (a *App) HandleCheckIPQuality(w http.ResponseWriter, r *http.Request) {
 		a.logger.Debug().Msg("Using API Key provided in request")
 	}
 
-	a.logger.Info().Int("count", len(body.Proxies)).Msg("Starting IPQuality check")
-	results := make([]models.IPQualityResult, 0)
-	wp := checker.NewWorkerPool(a.config.Worker.PoolSize)
+	ctx := r.Context()
+	job, run := a.startQualityCheck(ctx, adm, targets, effectiveAPIKey, opts)
+	w.Header().Set("X-Job-ID", job.ID)
+	results := run()
 
-	wp.Start(func(job checker.Job) interface{} {
-		proxyStr := job.Data.(string)
+	if ctx.Err() != nil {
+		a.logger.Warn().Err(ctx.Err()).Int("completed", len(results)).Msg("IPQuality check cancelled")
+		return
+	}
 
-		// Extract Host and Port for default display
-		host := proxyStr
-		port := ""
-		if idx := strings.Index(host, ":"); idx != -1 {
-			port = host[idx+1:]
-			host = host[:idx]
-			if idx2 := strings.Index(port, ":"); idx2 != -1 {
-				port = port[:idx2]
-			}
-		}
+	summary := a.providers.Summary(opts.Stats, len(results))
+	summary.Concurrency = job.Concurrency()
+	a.logger.Info().Int("count", summary.Total).Int64("calls_saved", summary.CallsSaved).Int64("cache_hits", summary.CacheHits).Int("concurrency", summary.Concurrency).Msg("IPQuality check finished")
+	writeCheckResponse(w, results, summary, body.Summary)
+}
 
-		ua := proxy.GetRandomUserAgent()
-		// NewProxyClient now handles protocol normalization internally
-		client, err := proxy.NewProxyClient(proxyStr, ua, a.config.Proxy.ConnectionTimeout)
+// qualityTarget is one proxy to check, or an input line that could not be parsed.
+type qualityTarget struct {
+	models.ProxyInput
+	raw string
+	err string
+}
+
+func proxyTargets(list []string) []qualityTarget {
+	targets := make([]qualityTarget, 0, len(list))
+	for _, proxyStr := range list {
+		input, err := parser.ParseProxy(proxyStr)
+		t := qualityTarget{ProxyInput: input, raw: proxyStr}
 		if err != nil {
-			a.logger.Error().Err(err).Str("proxy", proxyStr).Msg("Failed to create proxy client")
-			return models.IPQualityResult{IP: host, Port: port, Status: "Dead", Error: "Invalid proxy format"}
+			t.err = err.Error()
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// inputTargets wraps proxies that were parsed elsewhere, keeping their labels and TLS settings.
+func inputTargets(inputs []models.ProxyInput) []qualityTarget {
+	targets := make([]qualityTarget, 0, len(inputs))
+	for _, input := range inputs {
+		targets = append(targets, qualityTarget{ProxyInput: input, raw: input.Normalized()})
+	}
+	return targets
+}
+
+// inventoryTargets returns the inventory proxies carrying tag, with their inventory tags.
+func (a *App) inventoryTargets(tag string) ([]qualityTarget, error) {
+	list, _, err := a.inventory.List(storage.InventoryFilter{Tag: tag})
+	if err != nil {
+		return nil, err
+	}
+	inputs := make([]models.ProxyInput, 0, len(list))
+	for _, p := range list {
+		p.Proxy.Tags = p.Tags
+		inputs = append(inputs, p.Proxy)
+	}
+	return inputTargets(inputs), nil
+}
+
+// startQualityCheck registers the check job of a proxy list; run checks every
+// proxy and returns the results in input order.
+func (a *App) startQualityCheck(ctx context.Context, adm checkAdmission, targets []qualityTarget, apiKey string, opts checker.LookupOptions) (*checker.Job, func() []models.IPQualityResult) {
+	a.logger.Info().Int("count", len(targets)).Str("tenant", adm.tenant).Msg("Starting IPQuality check")
+	pool, job := newCheckPool(a, "quality", adm, len(targets), func(ctx context.Context, t qualityTarget) models.IPQualityResult {
+		res := a.checkProxyQuality(ctx, t, apiKey, opts)
+		res.Label = t.Label
+		return res
+	}, func(res models.IPQualityResult) error {
+		switch {
+		case res.Status != "Live":
+			return checker.ErrTargetFailed
+		case res.Error != "" && res.Category == parser.CategoryPublic:
+			// The proxy answered but the reputation lookup failed
+			return errors.New(res.Error)
 		}
+		return nil
+	})
 
-		// Step 0: TCP Pre-Check (Verify port reachability)
-		a.logger.Info().Str("proxy", proxyStr).Msg(">>> STEP 0: Verifying TCP Port Reachability")
-		if err := client.RawTCPCheck(); err != nil {
-			a.logger.Warn().Err(err).Str("proxy", proxyStr).Msg("Proxy Port Unreachable")
-			return models.IPQualityResult{IP: host, Port: port, Status: "Dead", Error: "TCP unreachable: " + err.Error()}
+	return job, func() []models.IPQualityResult {
+		results := make([]models.IPQualityResult, 0, len(targets))
+		checked := make([]models.CheckedProxy, 0, len(targets))
+		defer func() {
+			a.jobs.Finish(job, checked)
+			a.recordInventory(checked)
+		}()
+		for res := range pool.StreamOrdered(ctx, slices.Values(targets)) {
+			t := targets[res.Index]
+			if res.Err != nil {
+				a.logger.Error().Err(res.Err).Str("proxy", t.raw).Msg("IPQuality check crashed")
+				res.Value = models.IPQualityResult{IP: t.raw, Label: t.Label, Status: "Dead", Error: res.Err.Error()}
+			}
+			results = append(results, res.Value)
+			checked = append(checked, models.CheckedProxy{Proxy: t.ProxyInput, Result: res.Value})
+			job.Advance()
 		}
-		a.logger.Info().Str("proxy", proxyStr).Msg("TCP Port is OPEN")
+		return results
+	}
+}
 
-		// Step 1: Connectivity Check & Exit IP Detection
-		// Switch to Amazon CheckIP for better reliability and to get our actual IP
-		testTarget := "http://checkip.amazonaws.com"
-		a.logger.Info().Str("proxy", proxyStr).Msg(">>> STEP 1: Testing Connectivity & Detecting Exit IP")
-		testResp, err := client.HTTPClient.Get(testTarget)
+// recordInventory stores the results of the checked inventory proxies as
+// their last result and history.
+func (a *App) recordInventory(checked []models.CheckedProxy) {
+	n, err := a.inventory.RecordChecks(checked)
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Failed to record check results in the inventory")
+		return
+	}
+	if n > 0 {
+		a.logger.Debug().Int("count", n).Msg("Inventory check results recorded")
+	}
+}
 
-		// Protocol Fallback: If no protocol was specified and HTTP failed, try SOCKS5
-		if err != nil && !strings.Contains(proxyStr, "://") {
-			a.logger.Info().Str("proxy", proxyStr).Msg("HTTP check failed. Trying SOCKS5 fallback...")
-			s5Client, s5Err := proxy.NewProxyClient("socks5://"+proxyStr, ua, a.config.Proxy.ConnectionTimeout)
-			if s5Err == nil {
-				a.logger.Info().Str("proxy", proxyStr).Msg(">>> STEP 2: Testing SOCKS5 Connectivity")
-				testResp, s5Err = s5Client.HTTPClient.Get(testTarget)
-				if s5Err == nil {
-					a.logger.Info().Str("proxy", proxyStr).Msg("SOCKS5 connectivity verified successfully!")
-					client = s5Client // Switch to SOCKS5 client for subsequent checks
-					err = nil
-				} else {
-					a.logger.Warn().Str("proxy", proxyStr).Err(s5Err).Msg("SOCKS5 fallback also failed.")
-				}
+// checkProxyQuality runs the connectivity stages for one proxy and looks up
+// the reputation of its exit IP, within the per-proxy and per-stage deadlines.
+func (a *App) checkProxyQuality(ctx context.Context, t qualityTarget, effectiveAPIKey string, opts checker.LookupOptions) models.IPQualityResult {
+	ctx, cancel := checker.WithBudget(ctx, a.config.Deadlines.PerProxy)
+	defer cancel()
+
+	proxyStr, input := t.raw, t.ProxyInput
+	if t.err != "" {
+		a.logger.Error().Str("error", t.err).Str("proxy", proxyStr).Msg("Invalid proxy")
+		return models.IPQualityResult{IP: proxyStr, Status: "Dead", Error: "Invalid proxy format: " + t.err}
+	}
+	host, port := input.Host, input.Port
+	if addr, err := netip.ParseAddr(host); err == nil && !a.config.Proxy.AllowPrivateHosts {
+		if special := parser.ClassifyAddr(addr); special.Category != parser.CategoryPublic {
+			a.logger.Warn().Str("proxy", proxyStr).Str("category", special.Category).Msg("Non-routable proxy host not allowed")
+			return models.IPQualityResult{
+				IP:       host,
+				Port:     port,
+				Status:   "Dead",
+				Error:    "Proxy host is non-routable (" + special.Name + "); set proxy.allow_private_hosts to check it",
+				Category: special.Category,
 			}
 		}
+	}
+
+	ua := proxy.GetRandomUserAgent()
+	client, err := proxy.NewProxyClientFromInput(input, ua, a.config.Proxy.ConnectionTimeout)
+	if err != nil {
+		a.logger.Error().Err(err).Str("proxy", proxyStr).Msg("Failed to create proxy client")
+		return models.IPQualityResult{IP: host, Port: port, Status: "Dead", Error: "Invalid proxy format: " + err.Error()}
+	}
+
+	// Attempts of the connectivity stages, merged into the final result
+	var retries models.RetryInfo
 
+	// Step 0: TCP Pre-Check (Verify port reachability)
+	a.logger.Info().Str("proxy", proxyStr).Msg(">>> STEP 0: Verifying TCP Port Reachability")
+	tcpCtx, cancelTCP := checker.WithBudget(ctx, a.config.Deadlines.TCPCheck)
+	attempts, errs, err := a.retry.Do(tcpCtx, func() error {
+		release, err := a.dials.Acquire(tcpCtx, client.Proxy.Hostname())
 		if err != nil {
-			a.logger.Warn().Err(err).Str("proxy", proxyStr).Msg("Proxy is DEAD - Protocol/Auth failed.")
-			return models.IPQualityResult{IP: host, Port: port, Status: "Dead", Error: "Protocol failed: " + err.Error()}
+			return err
 		}
+		defer release()
+		return client.RawTCPCheck(tcpCtx)
+	})
+	cancelTCP()
+	retries.RecordAttempts("tcp", attempts, errs)
+	if err != nil {
+		a.logger.Warn().Err(err).Str("proxy", proxyStr).Int("attempts", attempts).Msg("Proxy Port Unreachable")
+		return models.IPQualityResult{IP: host, Port: port, Status: "Dead", Error: "TCP unreachable: " + err.Error(), RetryInfo: retries}
+	}
+	a.logger.Info().Str("proxy", proxyStr).Msg("TCP Port is OPEN")
 
-		// Read the Exit IP from the response
-		exitIPBytes, _ := io.ReadAll(io.LimitReader(testResp.Body, 1024))
-		testResp.Body.Close()
-		exitIP := strings.TrimSpace(string(exitIPBytes))
+	// Step 1: Connectivity Check & Exit IP Detection
+	// Switch to Amazon CheckIP for better reliability and to get our actual IP
+	testTarget := "http://checkip.amazonaws.com"
+	a.logger.Info().Str("proxy", proxyStr).Msg(">>> STEP 1: Testing Connectivity & Detecting Exit IP")
+	connCtx, cancelConn := checker.WithBudget(ctx, a.config.Deadlines.Connectivity)
+	defer cancelConn()
+	exitIP, latency, err := a.detectExitIP(connCtx, client, testTarget, "connect", &retries)
 
-		if exitIP == "" {
-			a.logger.Warn().Str("proxy", proxyStr).Msg("Could not detect Exit IP")
-			return models.IPQualityResult{IP: host, Port: port, Status: "Dead", Error: "Could not detect Exit IP"}
+	// Protocol Fallback: If no protocol was specified and HTTP failed, try SOCKS5
+	if err != nil && input.Scheme == "" {
+		a.logger.Info().Str("proxy", proxyStr).Msg("HTTP check failed. Trying SOCKS5 fallback...")
+		s5Input := input
+		s5Input.Scheme = "socks5"
+		s5Client, s5Err := proxy.NewProxyClientFromInput(s5Input, ua, a.config.Proxy.ConnectionTimeout)
+		if s5Err == nil {
+			a.logger.Info().Str("proxy", proxyStr).Msg(">>> STEP 2: Testing SOCKS5 Connectivity")
+			exitIP, latency, s5Err = a.detectExitIP(connCtx, s5Client, testTarget, "connect_socks5", &retries)
+			if s5Err == nil {
+				a.logger.Info().Str("proxy", proxyStr).Msg("SOCKS5 connectivity verified successfully!")
+				client = s5Client // Switch to SOCKS5 client for subsequent checks
+				err = nil
+			} else {
+				a.logger.Warn().Str("proxy", proxyStr).Err(s5Err).Msg("SOCKS5 fallback also failed.")
+			}
 		}
-		a.logger.Info().Str("proxy", proxyStr).Str("exit_ip", exitIP).Msg("Proxy is LIVE")
+	}
 
-		// Step 2: Quality Check (Use the ACTUAL Exit IP)
-		var res *models.IPQualityResult
-		// err is already declared in the outer scope of the closure
+	if err != nil {
+		a.logger.Warn().Err(err).Str("proxy", proxyStr).Msg("Proxy is DEAD - Protocol/Auth failed.")
+		reason := "Protocol failed: "
+		var statusErr *checker.HTTPStatusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusProxyAuthRequired {
+			reason = "Proxy authentication failed: "
+		} else if errors.Is(err, errNoExitIP) {
+			reason = ""
+		}
+		return models.IPQualityResult{IP: host, Port: port, Status: "Dead", Error: reason + err.Error(), RetryInfo: retries}
+	}
+	a.logger.Info().Str("proxy", proxyStr).Str("exit_ip", exitIP).Msg("Proxy is LIVE")
 
-		// Try Official API first if API key is set
-		if effectiveAPIKey != "" {
-			maskedKey := "set"
-			if len(effectiveAPIKey) > 8 {
-				maskedKey = effectiveAPIKey[:4] + "****" + effectiveAPIKey[len(effectiveAPIKey)-4:]
+	if addr, err := netip.ParseAddr(exitIP); err == nil {
+		if special := parser.ClassifyAddr(addr); special.Category != parser.CategoryPublic {
+			return models.IPQualityResult{
+				IP:        exitIP,
+				Port:      port,
+				Protocol:  client.Proxy.Scheme,
+				LatencyMS: latency.Milliseconds(),
+				Status:    "Live",
+				Error:     "Exit IP is non-routable (" + special.Name + "), reputation not looked up",
+				Category:  special.Category,
+				RetryInfo: retries,
 			}
-			a.logger.Info().Str("exit_ip", exitIP).Str("api_key", maskedKey).Msg("Using Official IPQuality API...")
-			res, err = checker.CheckIPQualityAPI(effectiveAPIKey, exitIP, client)
-		} else {
-			a.logger.Info().Str("exit_ip", exitIP).Msg("No API Key set, using scraping method...")
-			// No API key, use scraping
-			res, err = checker.CheckIPQuality(exitIP, client)
 		}
+	}
 
-		if err != nil {
-			// HYBRID FALLBACK: If proxy check is blocked (403) or API failed, try checking directly from Local IP
-			if strings.Contains(err.Error(), "403") || effectiveAPIKey != "" {
-				a.logger.Info().Str("exit_ip", exitIP).Str("proxy", proxyStr).Msg("[Fallback] Check failed. Trying Direct Check...")
+	// Step 2: Quality Check (Use the ACTUAL Exit IP)
+	repCtx, cancelRep := checker.WithBudget(ctx, a.config.Deadlines.Reputation)
+	defer cancelRep()
+	var res *models.IPQualityResult
+	// err is already declared in the outer scope of the closure
 
-				// Try API directly first
-				if effectiveAPIKey != "" {
-					res, err = checker.CheckIPQualityAPI(effectiveAPIKey, exitIP, nil)
-				}
+	// Try Official API first if API key is set
+	if effectiveAPIKey != "" {
+		maskedKey := "set"
+		if len(effectiveAPIKey) > 8 {
+			maskedKey = effectiveAPIKey[:4] + "****" + effectiveAPIKey[len(effectiveAPIKey)-4:]
+		}
+		a.logger.Info().Str("exit_ip", exitIP).Str("api_key", maskedKey).Msg("Using Official IPQuality API...")
+		res, err = a.providers.IPQualityAPI(repCtx, effectiveAPIKey, exitIP, client, opts)
+	} else {
+		a.logger.Info().Str("exit_ip", exitIP).Msg("No API Key set, using scraping method...")
+		// No API key, use scraping
+		res, err = a.providers.IPQuality(repCtx, exitIP, client, opts)
+	}
 
-				// If Direct API failed or wasn't used, try Scraping directly
-				if err != nil || effectiveAPIKey == "" {
-					res, err = checker.CheckIPQuality(exitIP, nil) // passing nil for proxyClient means Direct Check
-				}
+	if err != nil {
+		// HYBRID FALLBACK: If proxy check is blocked (403) or API failed, try checking directly from Local IP
+		if strings.Contains(err.Error(), "403") || errors.Is(err, checker.ErrCircuitOpen) || effectiveAPIKey != "" {
+			a.logger.Info().Str("exit_ip", exitIP).Str("proxy", proxyStr).Msg("[Fallback] Check failed. Trying Direct Check...")
 
-				if err != nil || (res != nil && res.FraudScore == "") {
-					a.logger.Info().Str("exit_ip", exitIP).Msg("[Fallback] IPQualityScore failed. Trying Scamalytics...")
-					res, err = checker.CheckScamalytics(exitIP, nil)
-				}
+			// Try API directly first
+			if effectiveAPIKey != "" {
+				res, err = a.providers.IPQualityAPI(repCtx, effectiveAPIKey, exitIP, nil, opts)
+			}
 
-				if err != nil || (res != nil && res.FraudScore == "") {
-					a.logger.Info().Str("exit_ip", exitIP).Msg("[Fallback] Scamalytics failed. Trying AbuseIPDB...")
-					res, err = checker.CheckAbuseIPDB(exitIP, nil)
-				}
+			// If Direct API failed or wasn't used, try Scraping directly
+			if err != nil || effectiveAPIKey == "" {
+				res, err = a.providers.IPQuality(repCtx, exitIP, nil, opts) // passing nil for proxyClient means Direct Check
+			}
 
-				if err == nil {
-					if res.Error == "" {
-						res.Error = "(Source: Direct Check)"
-					}
-				}
+			if err != nil || (res != nil && res.FraudScore == "") {
+				a.logger.Info().Str("exit_ip", exitIP).Msg("[Fallback] IPQualityScore failed. Trying Scamalytics...")
+				res, err = a.providers.Scamalytics(repCtx, exitIP, nil, opts)
 			}
 
-			if err != nil {
-				a.logger.Error().Err(err).Str("exit_ip", exitIP).Str("proxy", proxyStr).Msg("IPQuality check failed even with fallback")
-				// Even if IPQuality fails, it's still 'Live' because step 1 passed
-				return models.IPQualityResult{IP: exitIP, Port: port, Status: "Live", Error: "Quality info failed: " + err.Error()}
+			if err != nil || (res != nil && res.FraudScore == "") {
+				a.logger.Info().Str("exit_ip", exitIP).Msg("[Fallback] Scamalytics failed. Trying AbuseIPDB...")
+				res, err = a.providers.AbuseIPDB(repCtx, exitIP, nil, opts)
+			}
+
+			if err == nil {
+				res.Direct = true
 			}
 		}
-		res.Port = port
-		res.Status = "Live" // Ensure status is Live if we reach here
-		return *res
-	})
 
-	for i, p := range body.Proxies {
-		wp.AddJob(checker.Job{ID: i, Type: "ipquality", Data: p})
+		if err != nil {
+			a.logger.Error().Err(err).Str("exit_ip", exitIP).Str("proxy", proxyStr).Msg("IPQuality check failed even with fallback")
+			// Even if IPQuality fails, it's still 'Live' because step 1 passed
+			return models.IPQualityResult{
+				IP:              exitIP,
+				Port:            port,
+				Protocol:        client.Proxy.Scheme,
+				LatencyMS:       latency.Milliseconds(),
+				Status:          "Live",
+				Error:           "Quality info failed: " + err.Error(),
+				Category:        parser.CategoryPublic,
+				ConnectionClass: a.classifier.Classify(ctx, checker.ClassifyInput{IP: exitIP}),
+				RetryInfo:       retries,
+			}
+		}
 	}
-
-	for i := 0; i < len(body.Proxies); i++ {
-		res := <-wp.Results()
-		results = append(results, res.(models.IPQualityResult))
+	res.Port = port
+	res.Protocol = client.Proxy.Scheme
+	res.LatencyMS = latency.Milliseconds()
+	res.Status = "Live" // Ensure status is Live if we reach here
+	res.Category = parser.CategoryPublic
+	// The API reports ISO codes, scraping and Scamalytics full names
+	if code, name, ok := models.LookupCountry(res.Country); ok {
+		res.CountryCode, res.Country = code, name
+	}
+	res.ConnectionClass = a.classifier.Classify(ctx, checker.ClassifyInput{
+		IP:                     res.IP,
+		ASN:                    res.ASN,
+		ISP:                    res.ISP,
+		Organization:           res.Organization,
+		Hostname:               res.Hostname,
+		ProviderConnectionType: res.ProviderConnectionType,
+		ProviderMobile:         res.Mobile,
+	})
+	retries.Attempts += res.Attempts
+	res.RetryInfo = models.RetryInfo{
+		Attempts:      retries.Attempts,
+		AttemptErrors: append(retries.AttemptErrors, res.AttemptErrors...),
 	}
-	wp.Stop()
+	return *res
+}
+
+// errNoExitIP means the exit IP service answered with something other than an address.
+var errNoExitIP = errors.New("could not detect exit IP")
+
+// detectExitIP GETs target through the proxy and reads the exit IP it answers
+// with, retrying transient failures, and records the attempts under step.
+// A non-2xx answer, which may come from the proxy itself, is an
+// HTTPStatusError. The latency is that of the last attempt.
+func (a *App) detectExitIP(ctx context.Context, client *proxy.ProxyClient, target, step string, retries *models.RetryInfo) (string, time.Duration, error) {
+	var exitIP string
+	var latency time.Duration
+	attempts, errs, err := a.retry.Do(ctx, func() error {
+		release, err := a.dials.Acquire(ctx, client.Proxy.Hostname())
+		if err != nil {
+			return err
+		}
+		defer release()
+		start := time.Now()
+		resp, err := client.Get(ctx, target)
+		latency = time.Since(start)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return &checker.HTTPStatusError{Provider: "exit IP check", StatusCode: resp.StatusCode}
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if err != nil {
+			return err
+		}
+		addr, err := netip.ParseAddr(strings.TrimSpace(string(body)))
+		if err != nil {
+			return fmt.Errorf("%w: answer is not an IP address", errNoExitIP)
+		}
+		exitIP = addr.Unmap().String()
+		return nil
+	})
+	retries.RecordAttempts(step, attempts, errs)
+	return exitIP, latency, err
+}
 
+// writeCheckResponse writes the bare result list, or a {results, summary}
+// envelope when the client asked for the summary.
+func writeCheckResponse(w http.ResponseWriter, results interface{}, summary models.CheckSummary, withSummary bool) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(results)
+	if !withSummary {
+		json.NewEncoder(w).Encode(results)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": results,
+		"summary": summary,
+	})
 }
 
 func (a *App) HandleSetAPIKey(w http.ResponseWriter, r *http.Request) {
@@ -290,3 +997,607 @@ func (a *App) HandleSetAPIKey(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+func (a *App) HandleImportClassifierData(w http.ResponseWriter, r *http.Request) {
+	var (
+		n   int
+		err error
+	)
+	switch chi.URLParam(r, "kind") {
+	case "asn":
+		n, err = a.classifier.ImportASN(r.Body)
+	case "cloud":
+		n, err = a.classifier.ImportCloudRanges(r.Body)
+	default:
+		http.Error(w, "unknown import kind, expected asn or cloud", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.logger.Info().Int("imported", n).Str("kind", chi.URLParam(r, "kind")).Msg("Classifier data imported")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"imported": n,
+		"stats":    a.classifier.Stats(),
+	})
+}
+
+func (a *App) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	breakers := a.providers.BreakerStatuses()
+	status := "ok"
+	for _, b := range breakers {
+		if b.State != checker.BreakerClosed {
+			status = "degraded"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   status,
+		"breakers": breakers,
+	})
+}
+
+func (a *App) HandleLookupStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.providers.Stats())
+}
+
+func (a *App) HandleJobs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.jobs.Progress())
+}
+
+// HandleExportJob downloads the filtered results of a finished quality check
+// as a proxy list or a proxy client config.
+func (a *App) HandleExportJob(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := cmp.Or(q.Get("format"), export.FormatPlain)
+	if !slices.Contains(export.Formats, format) {
+		http.Error(w, fmt.Sprintf("invalid format %q: use %s", format, strings.Join(export.Formats, ", ")), http.StatusBadRequest)
+		return
+	}
+	filter := export.Filter{
+		Countries:       commaList(q.Get("country")),
+		ConnectionTypes: commaList(q.Get("connection_type")),
+	}
+	for _, country := range filter.Countries {
+		if _, _, ok := models.LookupCountry(country); !ok {
+			http.Error(w, fmt.Sprintf("invalid country %q", country), http.StatusBadRequest)
+			return
+		}
+	}
+	if v := q.Get("live"); v != "" {
+		live, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, "invalid live: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.LiveOnly = live
+	}
+	if v := q.Get("max_fraud_score"); v != "" {
+		score, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "invalid max_fraud_score: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		filter.MaxFraudScore = &score
+	}
+
+	id := chi.URLParam(r, "id")
+	checked, ok := a.checkedResults(w, id)
+	if !ok {
+		return
+	}
+
+	list := filter.Apply(checked)
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`, id, format, export.Extension(format)))
+	if err := export.Render(w, format, list, export.Options{Group: q.Get("group")}); err != nil {
+		a.logger.Error().Err(err).Str("job_id", id).Msg("Export failed")
+		return
+	}
+	a.logger.Info().Str("job_id", id).Str("format", format).Int("exported", len(list)).Int("total", len(checked)).Msg("Job exported")
+}
+
+// checkedResults returns the checked proxies of a finished quality job, or
+// writes the error response.
+func (a *App) checkedResults(w http.ResponseWriter, id string) ([]models.CheckedProxy, bool) {
+	_, results, err := a.jobs.Results(id)
+	switch {
+	case errors.Is(err, checker.ErrJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	case errors.Is(err, checker.ErrJobRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return nil, false
+	}
+	checked, ok := results.([]models.CheckedProxy)
+	if !ok {
+		http.Error(w, fmt.Sprintf("job %s is not a quality check", id), http.StatusBadRequest)
+		return nil, false
+	}
+	return checked, true
+}
+
+// checkedPool returns the last result of every checked inventory proxy,
+// overlaid with the results of the retained quality jobs in the order they
+// finished, so the pool survives job retention and restarts. Inventory
+// proxies keep their inventory tags.
+func (a *App) checkedPool() []models.CheckedProxy {
+	var pool []models.CheckedProxy
+	index := make(map[string]int)
+	add := func(c models.CheckedProxy) {
+		key := c.Proxy.Normalized()
+		i, ok := index[key]
+		if !ok {
+			index[key] = len(pool)
+			pool = append(pool, c)
+			return
+		}
+		if len(c.Proxy.Tags) == 0 {
+			c.Proxy.Tags = pool[i].Proxy.Tags
+		}
+		pool[i] = c
+	}
+
+	list, _, err := a.inventory.List(storage.InventoryFilter{})
+	if err != nil {
+		a.logger.Error().Err(err).Msg("Failed to read the inventory results")
+	}
+	for _, p := range list {
+		if p.LastResult != nil {
+			p.Proxy.Tags = p.Tags
+			add(models.CheckedProxy{Proxy: p.Proxy, Result: *p.LastResult})
+		}
+	}
+	for _, results := range a.jobs.FinishedResults("quality") {
+		checked, _ := results.([]models.CheckedProxy)
+		for _, c := range checked {
+			add(c)
+		}
+	}
+	return pool
+}
+
+// HandlePAC serves a proxy auto-config file. GET uses the pac rules of the
+// config, POST the rules of the body. Proxies come from the given quality
+// jobs, else from the checked pool.
+func (a *App) HandlePAC(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Jobs    []string         `json:"jobs"`
+		Rules   []export.PACRule `json:"rules"`
+		Default string           `json:"default"`
+	}{
+		Jobs:    commaList(r.URL.Query().Get("job")),
+		Default: a.config.PAC.Default,
+	}
+	for _, rule := range a.config.PAC.Rules {
+		body.Rules = append(body.Rules, export.PACRule(rule))
+	}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	opts := export.PACOptions{Rules: body.Rules, Default: body.Default}
+	if err := opts.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var pool []models.CheckedProxy
+	if len(body.Jobs) == 0 {
+		pool = a.checkedPool()
+	}
+	for _, id := range body.Jobs {
+		checked, ok := a.checkedResults(w, id)
+		if !ok {
+			return
+		}
+		pool = append(pool, checked...)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	w.Header().Set("Content-Disposition", `attachment; filename="proxy.pac"`)
+	w.Header().Set("Cache-Control", "no-store")
+	if err := export.RenderPAC(w, pool, opts); err != nil {
+		a.logger.Error().Err(err).Msg("PAC generation failed")
+	}
+}
+
+// commaList splits a comma-separated query value, dropping empty items.
+func commaList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func (a *App) HandleGatewayStatus(w http.ResponseWriter, r *http.Request) {
+	if a.gateway == nil {
+		http.Error(w, "proxy gateway is disabled", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.gateway.Status())
+}
+
+// HandleLease hands out the fastest free live proxy matching the criteria.
+func (a *App) HandleLease(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		leases.Criteria
+		TTL string `json:"ttl"` // a duration or seconds
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ttl, err := durationOrSeconds(body.TTL)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid ttl %q", body.TTL), http.StatusBadRequest)
+		return
+	}
+	lease, err := a.leases.Lease(body.Criteria, ttl)
+	if errors.Is(err, leases.ErrNoProxy) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	adm, _ := a.admission(r, "", "")
+	a.logger.Info().Str("lease_id", lease.ID).Str("proxy", lease.Proxy.Address()).Time("expires_at", lease.ExpiresAt).Str("tenant", adm.tenant).Msg("Proxy leased")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lease)
+}
+
+func (a *App) HandleReleaseLease(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !a.leases.Release(id) {
+		http.Error(w, fmt.Sprintf("lease %q not found or expired", id), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleReportBadLease ends a lease and queues its proxy for a recheck.
+func (a *App) HandleReportBadLease(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	id := chi.URLParam(r, "id")
+	if !a.leases.ReportBad(id, body.Reason) {
+		http.Error(w, fmt.Sprintf("lease %q not found or expired", id), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *App) HandlePoolStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.leases.Status())
+}
+
+// HandleInventory lists the inventory proxies, optionally filtered by tag,
+// supplier, cost group and last check status, and paged with limit and offset.
+func (a *App) HandleInventory(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := storage.InventoryFilter{
+		Tag:       q.Get("tag"),
+		Supplier:  q.Get("supplier"),
+		CostGroup: q.Get("cost_group"),
+		Status:    q.Get("status"),
+	}
+	for key, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if v := q.Get(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid %s %q", key, v), http.StatusBadRequest)
+				return
+			}
+			*dst = n
+		}
+	}
+	list, total, err := a.inventory.List(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Total   int                      `json:"total"`
+		Proxies []storage.InventoryProxy `json:"proxies"`
+	}{total, list})
+}
+
+// inventoryBody is the metadata of an inventory proxy in a request.
+type inventoryBody struct {
+	Supplier  string   `json:"supplier"`
+	CostGroup string   `json:"cost_group"`
+	Tags      []string `json:"tags"`
+}
+
+func (a *App) HandleAddInventoryProxy(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Proxy string `json:"proxy"`
+		inventoryBody
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	input, err := parser.ParseProxy(body.Proxy)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := a.inventory.Add(storage.InventoryProxy{Proxy: input, Supplier: body.Supplier, CostGroup: body.CostGroup, Tags: body.Tags})
+	if err != nil {
+		inventoryError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(p)
+}
+
+// HandleImportInventory adds a proxy list, pasted or from an upload, to the
+// inventory under one supplier, cost group and tag set. Proxies already in
+// the inventory get the new metadata merged in.
+func (a *App) HandleImportInventory(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Proxies  []string `json:"proxies"`
+		ImportID string   `json:"import_id"`
+		inventoryBody
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var inputs []models.ProxyInput
+	rejected := []parser.LineError{}
+	for i, line := range body.Proxies {
+		input, err := parser.ParseProxy(line)
+		if err != nil {
+			rejected = append(rejected, parser.LineError{Line: i + 1, Input: line, Reason: err.Error()})
+			continue
+		}
+		inputs = append(inputs, input)
+	}
+	var ok bool
+	if inputs, ok = importedList(a, w, body.ImportID, inputs, func(imp *checker.Import) []models.ProxyInput { return imp.Proxies }); !ok {
+		return
+	}
+
+	res, err := a.inventory.Import(inputs, body.Supplier, body.CostGroup, body.Tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.logger.Info().Int("added", res.Added).Int("updated", res.Updated).Int("rejected", len(rejected)).Str("supplier", body.Supplier).Msg("Proxies imported into the inventory")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		storage.InventoryImport
+		Rejected []parser.LineError `json:"rejected"`
+	}{res, rejected})
+}
+
+func (a *App) HandleInventoryProxy(w http.ResponseWriter, r *http.Request) {
+	id, ok := inventoryID(w, r)
+	if !ok {
+		return
+	}
+	p, err := a.inventory.Get(id)
+	if err != nil {
+		inventoryError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// HandleUpdateInventoryProxy replaces the supplier, cost group and tags of a proxy.
+func (a *App) HandleUpdateInventoryProxy(w http.ResponseWriter, r *http.Request) {
+	id, ok := inventoryID(w, r)
+	if !ok {
+		return
+	}
+	var body inventoryBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p, err := a.inventory.Update(id, body.Supplier, body.CostGroup, body.Tags)
+	if err != nil {
+		inventoryError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+func (a *App) HandleDeleteInventoryProxy(w http.ResponseWriter, r *http.Request) {
+	id, ok := inventoryID(w, r)
+	if !ok {
+		return
+	}
+	if err := a.inventory.Delete(id); err != nil {
+		inventoryError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleInventoryHistory returns the retained checks of a proxy, newest first.
+func (a *App) HandleInventoryHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := inventoryID(w, r)
+	if !ok {
+		return
+	}
+	history, err := a.inventory.History(id)
+	if err != nil {
+		inventoryError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+func inventoryID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid proxy id %q", chi.URLParam(r, "id")), http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func inventoryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrProxyNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, storage.ErrProxyExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (a *App) HandleSubscriptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.subs.Status())
+}
+
+// HandleAddSubscription adds a subscription for the lifetime of the process;
+// sources that should survive a restart belong in config.yaml.
+func (a *App) HandleAddSubscription(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name      string            `json:"name"`
+		URL       string            `json:"url"`
+		Headers   map[string]string `json:"headers"`
+		Format    string            `json:"format"`
+		Delimiter string            `json:"delimiter"`
+		Header    string            `json:"header"`
+		Columns   parser.Columns    `json:"columns"`
+		Interval  string            `json:"interval"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cfg := storage.SubscriptionConfig{
+		Name:      body.Name,
+		URL:       body.URL,
+		Headers:   body.Headers,
+		Format:    body.Format,
+		Delimiter: body.Delimiter,
+		Header:    body.Header,
+		Columns:   storage.SubscriptionColumns(body.Columns),
+	}
+	if body.Interval != "" {
+		d, err := time.ParseDuration(body.Interval)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid interval %q", body.Interval), http.StatusBadRequest)
+			return
+		}
+		cfg.Interval = d
+	}
+
+	status, err := a.subs.Add(cfg, "api")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.logger.Info().Str("subscription", cfg.Name).Str("interval", status.Interval).Msg("Subscription added")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(status)
+}
+
+func (a *App) HandleDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	if !a.subs.Remove(name) {
+		http.Error(w, fmt.Sprintf("subscription %q not found", name), http.StatusNotFound)
+		return
+	}
+	a.logger.Info().Str("subscription", name).Msg("Subscription removed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *App) HandleRefreshSubscription(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "name")
+	status, ok := a.subs.Refresh(r.Context(), name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("subscription %q not found", name), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+func (a *App) HandleSchedulerStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.scheduler.Status())
+}
+
+func (a *App) HandleRateLimits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checker.RateLimitStates())
+}
+
+func (a *App) HandleCacheStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := a.cache.Stats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (a *App) HandleCachePurge(w http.ResponseWriter, r *http.Request) {
+	ip := r.URL.Query().Get("ip")
+	provider := r.URL.Query().Get("provider")
+	if ip == "" && provider == "" && r.URL.Query().Get("all") != "true" {
+		http.Error(w, "ip or provider is required (or all=true)", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := a.cache.Purge(ip, provider)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.logger.Info().Str("ip", ip).Str("provider", provider).Int64("deleted", deleted).Msg("Cache purged")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"deleted": deleted})
+}
+
+func (a *App) HandleCacheVacuum(w http.ResponseWriter, r *http.Request) {
+	deleted, err := a.cache.Vacuum()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"deleted": deleted})
+}
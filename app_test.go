@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"ip-proxy-checker/internal/checker"
+	"ip-proxy-checker/internal/leases"
+	"ip-proxy-checker/internal/models"
+	"ip-proxy-checker/internal/proxy"
+	"ip-proxy-checker/internal/storage"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDetectExitIPChecksTheAnswer(t *testing.T) {
+	a := &App{retry: checker.RetryPolicy{MaxAttempts: 3}, dials: checker.NewDialLimiter(checker.DialLimits{})}
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		attempts int
+		wantIP   string
+		check    func(error) bool
+	}{
+		{"auth required", http.StatusProxyAuthRequired, "", 1, "", isStatus(http.StatusProxyAuthRequired)},
+		{"bad gateway", http.StatusBadGateway, "<html>upstream down</html>", 3, "", isStatus(http.StatusBadGateway)},
+		{"error page", http.StatusOK, "<html>blocked</html>", 1, "", func(err error) bool { return errors.Is(err, errNoExitIP) }},
+		{"exit ip", http.StatusOK, "203.0.113.7\n", 1, "203.0.113.7", func(err error) bool { return err == nil }},
+	}
+	for _, tt := range tests {
+		var calls atomic.Int32
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.WriteHeader(tt.status)
+			io.WriteString(w, tt.body)
+		}))
+		host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+		client, err := proxy.NewProxyClientFromInput(models.ProxyInput{Scheme: "http", Host: host, Port: port}, "test", 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var retries models.RetryInfo
+		ip, _, err := a.detectExitIP(t.Context(), client, "http://checkip.example", "connect", &retries)
+		if !tt.check(err) || ip != tt.wantIP {
+			t.Errorf("%s: detectExitIP = %q, %v", tt.name, ip, err)
+		}
+		if n := int(calls.Load()); n != tt.attempts {
+			t.Errorf("%s: proxy saw %d attempts, want %d", tt.name, n, tt.attempts)
+		}
+		srv.Close()
+	}
+}
+
+func isStatus(code int) func(error) bool {
+	return func(err error) bool {
+		var statusErr *checker.HTTPStatusError
+		return errors.As(err, &statusErr) && statusErr.StatusCode == code
+	}
+}
+
+func TestLeaseOutlivesJobRetention(t *testing.T) {
+	inventory, err := storage.NewInventory(filepath.Join(t.TempDir(), "inventory.db"), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inventory.Close()
+	cfg, _ := storage.LoadConfig("")
+	a := &App{config: cfg, jobs: checker.NewJobs(20 * time.Millisecond), inventory: inventory}
+
+	p := models.ProxyInput{Scheme: "http", Host: "203.0.113.5", Port: "8080"}
+	if _, err := inventory.Add(storage.InventoryProxy{Proxy: p, Tags: []string{"supplier-a"}}); err != nil {
+		t.Fatal(err)
+	}
+	checked := []models.CheckedProxy{{Proxy: p, Result: models.IPQualityResult{Status: "Live", Protocol: "http", LatencyMS: 100}}}
+	job := a.jobs.Start("quality", "test", checker.PriorityInteractive, 1, 1, nil)
+	a.jobs.Finish(job, checked)
+	a.recordInventory(checked)
+
+	time.Sleep(50 * time.Millisecond)
+	if len(a.jobs.FinishedResults("quality")) != 0 {
+		t.Fatal("The job was not pruned")
+	}
+	pool := leases.New(a.config, a.checkedPool, func(context.Context, []models.ProxyInput) {})
+	lease, err := pool.Lease(leases.Criteria{Tags: []string{"supplier-a"}}, 0)
+	if err != nil || lease.Proxy.Host != p.Host {
+		t.Errorf("Lease after the job was pruned = %+v, %v", lease, err)
+	}
+}
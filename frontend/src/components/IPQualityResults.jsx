@@ -207,6 +207,11 @@ const IPQualityResults = ({ results = [], onExportCSV }) => {
                                                     {result.error}
                                                 </div>
                                             )}
+                                            {result.source && (
+                                                <div className="status-source-msg">
+                                                    {result.source}{result.direct ? ' (direct)' : ''}
+                                                </div>
+                                            )}
                                         </td>
                                         <td className="col-country">{result.country}</td>
                                         <td className="col-city">{result.city}</td>
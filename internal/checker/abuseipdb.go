@@ -1,6 +1,7 @@
 package checker
 
 import (
+	"context"
 	"fmt"
 	"ip-proxy-checker/internal/models"
 	"ip-proxy-checker/internal/proxy"
@@ -10,17 +11,17 @@ import (
 	"github.com/PuerkitoBio/goquery"
 )
 
-func CheckAbuseIPDB(ip string, proxyClient *proxy.ProxyClient) (*models.IPQualityResult, error) {
+func CheckAbuseIPDB(ctx context.Context, ip string, proxyClient *proxy.ProxyClient) (*models.IPQualityResult, error) {
 	url := fmt.Sprintf("https://www.abuseipdb.com/check/%s", ip)
 
-	httpClient := http.DefaultClient
+	httpClient := directClient
 	userAgent := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36"
 	if proxyClient != nil {
 		httpClient = proxyClient.HTTPClient
 		userAgent = proxyClient.UserAgent
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -28,14 +29,14 @@ func CheckAbuseIPDB(ip string, proxyClient *proxy.ProxyClient) (*models.IPQualit
 	req.Header.Set("User-Agent", userAgent)
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8")
 
-	resp, err := httpClient.Do(req)
+	resp, err := doProviderRequest(ctx, ProviderAbuseIPDB, httpClient, req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("abuseipdb bad status code: %d", resp.StatusCode)
+		return nil, &HTTPStatusError{Provider: ProviderAbuseIPDB, StatusCode: resp.StatusCode}
 	}
 
 	doc, err := goquery.NewDocumentFromReader(resp.Body)
@@ -68,9 +69,7 @@ func CheckAbuseIPDB(ip string, proxyClient *proxy.ProxyClient) (*models.IPQualit
 		}
 	})
 
-	if result.FraudScore != "" {
-		result.Error = "(Source: AbuseIPDB)"
-	}
+	result.Source = ProviderAbuseIPDB
 
 	return result, nil
 }
@@ -0,0 +1,163 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"ip-proxy-checker/internal/storage"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// AIMDConfig bounds and tunes an AIMD concurrency controller.
+type AIMDConfig struct {
+	Initial          int
+	Min              int
+	Max              int
+	BackoffFactor    float64 // multiplier applied on congestion, e.g. 0.5
+	ErrorThreshold   float64 // share of congestion errors in a window that triggers backoff
+	LatencyTolerance float64 // growth stops while window latency exceeds baseline * tolerance
+}
+
+// NewAIMDConfig reads the adaptive settings of the worker section. It reports
+// false when adaptive concurrency is disabled.
+func NewAIMDConfig(cfg *storage.Config) (AIMDConfig, bool) {
+	a := cfg.Worker.Adaptive
+	return AIMDConfig{
+		Initial:          cfg.Worker.PoolSize,
+		Min:              a.MinConcurrency,
+		Max:              a.MaxConcurrency,
+		BackoffFactor:    a.BackoffFactor,
+		ErrorThreshold:   a.ErrorThreshold,
+		LatencyTolerance: a.LatencyTolerance,
+	}, a.Enabled
+}
+
+// AIMD adapts how many jobs may run at once across every check. The
+// Scheduler admits no more than its Limit; finished jobs are reported with
+// Observe. Every window of roughly one job per slot it adds a slot if latency
+// stayed near its baseline, and multiplies the limit by BackoffFactor if
+// timeouts or connection resets exceeded ErrorThreshold.
+type AIMD struct {
+	cfg AIMDConfig
+
+	mu       sync.Mutex
+	limit    float64
+	baseline time.Duration // typical success latency at a healthy level
+
+	samples    int
+	congested  int
+	successes  int
+	latencySum time.Duration
+}
+
+func NewAIMD(cfg AIMDConfig) *AIMD {
+	if cfg.Min < 1 {
+		cfg.Min = 1
+	}
+	if cfg.Max < cfg.Min {
+		cfg.Max = cfg.Min
+	}
+	if cfg.BackoffFactor <= 0 || cfg.BackoffFactor >= 1 {
+		cfg.BackoffFactor = 0.5
+	}
+	if cfg.ErrorThreshold <= 0 {
+		cfg.ErrorThreshold = 0.1
+	}
+	if cfg.LatencyTolerance < 1 {
+		cfg.LatencyTolerance = 1.5
+	}
+	initial := min(max(cfg.Initial, cfg.Min), cfg.Max)
+	return &AIMD{cfg: cfg, limit: float64(initial)}
+}
+
+// Max is the upper bound of the limit, i.e. the number of workers a pool needs.
+func (c *AIMD) Max() int {
+	return c.cfg.Max
+}
+
+// Limit returns the current concurrency level.
+func (c *AIMD) Limit() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.limit)
+}
+
+// Observe records a job that ran for latency and failed with err (nil on
+// success). Errors that are not congestion count as samples only.
+func (c *AIMD) Observe(latency time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.samples++
+	switch {
+	case err == nil:
+		c.successes++
+		c.latencySum += latency
+	case IsCongestion(err):
+		c.congested++
+	}
+
+	if c.samples >= int(c.limit) {
+		c.adjust()
+	}
+}
+
+// adjust applies the decision for a finished window and starts a new one.
+func (c *AIMD) adjust() {
+	defer func() {
+		c.samples, c.congested, c.successes, c.latencySum = 0, 0, 0, 0
+	}()
+
+	if float64(c.congested)/float64(c.samples) > c.cfg.ErrorThreshold {
+		c.limit = math.Max(float64(c.cfg.Min), math.Floor(c.limit*c.cfg.BackoffFactor))
+		return
+	}
+	if c.successes == 0 {
+		return
+	}
+
+	avg := c.latencySum / time.Duration(c.successes)
+	switch {
+	case c.baseline == 0 || avg < c.baseline:
+		c.baseline = avg
+	default:
+		// Let the baseline follow slow drift so one lucky window does not pin it
+		c.baseline += (avg - c.baseline) / 10
+	}
+	if float64(avg) <= float64(c.baseline)*c.cfg.LatencyTolerance {
+		c.limit = math.Min(float64(c.cfg.Max), c.limit+1)
+	}
+}
+
+// ErrTargetFailed is the outcome of a check whose target, e.g. the proxy
+// under test, failed. Dead proxies say nothing about our own capacity, so it
+// is never congestion.
+var ErrTargetFailed = errors.New("check target failed")
+
+// IsCongestion reports whether err looks like saturation of our uplink or the
+// remote side: timeouts and connection resets. Results only keep the message,
+// so plain text errors are matched too.
+func IsCongestion(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, ErrTargetFailed) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"timeout", "deadline exceeded", "connection reset"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
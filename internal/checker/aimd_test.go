@@ -0,0 +1,100 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAIMDGrowsAndBacksOff(t *testing.T) {
+	c := NewAIMD(AIMDConfig{Initial: 4, Min: 2, Max: 10, BackoffFactor: 0.5, ErrorThreshold: 0.2, LatencyTolerance: 1.5})
+
+	window := func(n int, err error) {
+		for i := 0; i < n; i++ {
+			c.Observe(100*time.Millisecond, err)
+		}
+	}
+
+	for c.Limit() < 10 {
+		before := c.Limit()
+		window(before, nil)
+		if c.Limit() != before+1 {
+			t.Fatalf("Expected limit to grow from %d, got %d", before, c.Limit())
+		}
+	}
+	window(10, nil)
+	if c.Limit() != 10 {
+		t.Errorf("Limit exceeded max: %d", c.Limit())
+	}
+
+	window(10, errors.New("dial tcp 1.2.3.4:80: i/o timeout"))
+	if c.Limit() != 5 {
+		t.Errorf("Expected backoff to 5, got %d", c.Limit())
+	}
+	window(5, errors.New("read: connection reset by peer"))
+	window(2, errors.New("read: connection reset by peer"))
+	if c.Limit() != 2 {
+		t.Errorf("Expected limit to stop at min 2, got %d", c.Limit())
+	}
+
+	// Plain failures (refused, 4xx) are not congestion, but no successes
+	// means no latency signal to grow on either
+	window(2, errors.New("connection refused"))
+	if c.Limit() != 2 {
+		t.Errorf("Expected non-congestion errors to hold the limit, got %d", c.Limit())
+	}
+
+	// Dead proxies timing out are the target's fault, not congestion
+	for c.Limit() < 4 {
+		window(c.Limit(), nil)
+	}
+	window(4, fmt.Errorf("%w: TCP unreachable: i/o timeout", ErrTargetFailed))
+	if c.Limit() != 4 {
+		t.Errorf("Expected target failures to hold the limit, got %d", c.Limit())
+	}
+}
+
+func TestAIMDLimitsPoolsThroughScheduler(t *testing.T) {
+	c := NewAIMD(AIMDConfig{Initial: 2, Min: 2, Max: 2})
+	s := NewScheduler(8, nil, nil).Adapt(c)
+	var mu sync.Mutex
+	running, peak := 0, 0
+	newPool := func(tenant string) *WorkerPool[int, int] {
+		return NewWorkerPool(8, func(ctx context.Context, n int) int {
+			mu.Lock()
+			running++
+			peak = max(peak, running)
+			mu.Unlock()
+			time.Sleep(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return n
+		}).Gate(func(ctx context.Context) (func(), error) {
+			return s.Acquire(ctx, tenant, PriorityInteractive)
+		}).Adapt(c, func(int) error { return nil })
+	}
+
+	// Two jobs share the limit instead of ramping up each on their own
+	var wg sync.WaitGroup
+	for _, tenant := range []string{"a", "b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := newPool(tenant).Run(context.Background(), slices.Values(make([]int, 50))); err != nil {
+				t.Errorf("Run failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak > 2 {
+		t.Errorf("Expected at most 2 jobs at once, saw %d", peak)
+	}
+	if st := s.Status(); st.Limit != 2 || st.Capacity != 8 {
+		t.Errorf("Unexpected scheduler status %+v", st)
+	}
+}
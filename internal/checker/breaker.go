@@ -0,0 +1,120 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half_open"
+)
+
+// ErrCircuitOpen is returned for calls skipped because the provider's breaker is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+// CircuitBreaker stops calling a provider after consecutive failures. After the
+// cooldown a single probe call is let through (half-open); its outcome decides
+// whether the breaker closes again or re-opens.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+type BreakerStatus struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow reports whether a call may proceed. A threshold <= 0 disables the breaker.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		// Only the probe call goes through until it reports back
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+	b.probing = false
+}
+
+// Cancel gives back the probe slot of a call abandoned by its caller. Nothing
+// was learned about the provider, so the state stays as it is.
+func (b *CircuitBreaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if err != nil {
+		b.lastError = err.Error()
+	}
+	if b.threshold <= 0 {
+		return
+	}
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{State: b.state, Failures: b.failures, LastError: b.lastError}
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		status.State = BreakerHalfOpen
+	}
+	if b.state != BreakerClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+	return status
+}
+
+func circuitOpenError(provider string) error {
+	return fmt.Errorf("%s: %w", provider, ErrCircuitOpen)
+}
@@ -0,0 +1,52 @@
+package checker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	b := NewCircuitBreaker(2, 20*time.Millisecond)
+	failure := errors.New("ipquality bad status code: 403")
+
+	b.Failure(failure)
+	if !b.Allow() {
+		t.Fatalf("Breaker should stay closed below the threshold")
+	}
+	b.Failure(failure)
+	if b.Allow() {
+		t.Fatalf("Breaker should open at the threshold")
+	}
+	if b.Status().State != BreakerOpen {
+		t.Errorf("Expected open, got %s", b.Status().State)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatalf("Breaker should let a probe through after the cooldown")
+	}
+	if b.Allow() {
+		t.Errorf("Only one probe may run while half-open")
+	}
+	b.Failure(failure)
+	if b.Allow() {
+		t.Errorf("Failed probe should re-open the breaker")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatalf("Breaker should let a probe through after the cooldown")
+	}
+	b.Cancel()
+	if b.Status().State != BreakerHalfOpen {
+		t.Errorf("Cancelled probe changed the state to %s", b.Status().State)
+	}
+	if !b.Allow() {
+		t.Fatalf("Cancelled probe should free the probe slot")
+	}
+	b.Success()
+	if b.Status().State != BreakerClosed || !b.Allow() {
+		t.Errorf("Successful probe should close the breaker")
+	}
+}
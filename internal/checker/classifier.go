@@ -0,0 +1,454 @@
+package checker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"ip-proxy-checker/internal/models"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ConnResidential = "residential"
+	ConnMobile      = "mobile"
+	ConnDatacenter  = "datacenter"
+	ConnBusiness    = "business"
+	ConnUnknown     = "unknown"
+)
+
+// Signal weights. A single strong signal is not enough for full confidence;
+// confidence only reaches 1.0 once the agreeing weight reaches minConfidentWeight.
+const (
+	weightCloudRange     = 4
+	weightASN            = 3
+	weightProviderType   = 3
+	weightProviderMobile = 3
+	weightRDNS           = 2
+	weightISPName        = 1
+	minConfidentWeight   = 5
+)
+
+const maxClassifierLineSize = 1024 * 1024
+
+// rDNS lookups are bounded and cached, so a slow PTR server costs one
+// lookup per IP and never more than rdnsTimeout of a check.
+const (
+	rdnsTimeout   = 2 * time.Second
+	rdnsCacheTTL  = time.Hour
+	rdnsCacheSize = 50000
+)
+
+// ClassifyInput carries everything known about an IP that hints at its connection type.
+type ClassifyInput struct {
+	IP                     string
+	ASN                    string // "AS13335" or "13335"
+	ISP                    string
+	Organization           string
+	Hostname               string // rDNS, resolved on demand when empty
+	ProviderConnectionType string // e.g. IPQS "Residential", "Data Center"
+	ProviderMobile         bool
+}
+
+type cloudRange struct {
+	prefix   netip.Prefix
+	provider string
+}
+
+// Classifier derives a connection type from ASN data, cloud ranges, rDNS and provider hints.
+type Classifier struct {
+	mu          sync.RWMutex
+	asnTypes    map[int]string
+	cloudRanges []cloudRange
+
+	ResolveRDNS bool
+	lookupAddr  func(ctx context.Context, addr string) ([]string, error)
+
+	rdnsMu    sync.Mutex
+	rdnsCache map[string]rdnsEntry
+}
+
+type rdnsEntry struct {
+	hostname string // empty when the lookup failed
+	expires  time.Time
+}
+
+func NewClassifier() *Classifier {
+	return &Classifier{
+		asnTypes:   make(map[int]string),
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		rdnsCache:  make(map[string]rdnsEntry),
+	}
+}
+
+var rdnsKeywords = []struct {
+	keyword  string
+	connType string
+}{
+	{"mobile", ConnMobile},
+	{"lte", ConnMobile},
+	{"4g", ConnMobile},
+	{"5g", ConnMobile},
+	{"gprs", ConnMobile},
+	{"wireless", ConnMobile},
+	{"dsl", ConnResidential},
+	{"cable", ConnResidential},
+	{"dyn", ConnResidential},
+	{"pool", ConnResidential},
+	{"dhcp", ConnResidential},
+	{"ftth", ConnResidential},
+	{"fiber", ConnResidential},
+	{"broadband", ConnResidential},
+	{"cust", ConnResidential},
+	{"res", ConnResidential},
+	{"ppp", ConnResidential},
+	{"amazonaws", ConnDatacenter},
+	{"googleusercontent", ConnDatacenter},
+	{"cloudapp", ConnDatacenter},
+	{"linode", ConnDatacenter},
+	{"vultr", ConnDatacenter},
+	{"hetzner", ConnDatacenter},
+	{"ovh", ConnDatacenter},
+	{"contabo", ConnDatacenter},
+	{"digitalocean", ConnDatacenter},
+	{"vps", ConnDatacenter},
+	{"server", ConnDatacenter},
+	{"host", ConnDatacenter},
+	{"cloud", ConnDatacenter},
+	{"colo", ConnDatacenter},
+	{"static", ConnBusiness},
+	{"biz", ConnBusiness},
+	{"corp", ConnBusiness},
+}
+
+var ispKeywords = []struct {
+	keyword  string
+	connType string
+}{
+	{"mobile", ConnMobile},
+	{"wireless", ConnMobile},
+	{"cellular", ConnMobile},
+	{"hosting", ConnDatacenter},
+	{"data center", ConnDatacenter},
+	{"datacenter", ConnDatacenter},
+	{"cloud", ConnDatacenter},
+	{"server", ConnDatacenter},
+	{"vps", ConnDatacenter},
+	{"university", ConnBusiness},
+	{"college", ConnBusiness},
+	{"government", ConnBusiness},
+	{"broadband", ConnResidential},
+	{"cable", ConnResidential},
+	{"dsl", ConnResidential},
+	{"telecom", ConnResidential},
+}
+
+// normalizeConnType maps ASN-file and provider vocabularies onto our connection types.
+func normalizeConnType(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "residential", "isp", "broadband", "consumer":
+		return ConnResidential
+	case "mobile", "cellular", "wireless":
+		return ConnMobile
+	case "datacenter", "data center", "hosting", "cloud", "vps", "cdn":
+		return ConnDatacenter
+	case "business", "corporate", "education", "government", "enterprise":
+		return ConnBusiness
+	}
+	return ""
+}
+
+// parseASN accepts "AS13335", "13335" or "AS13335 Cloudflare, Inc." and returns the number.
+func parseASN(s string) (int, bool) {
+	s = strings.TrimSpace(s)
+	if fields := strings.Fields(s); len(fields) > 0 {
+		s = fields[0]
+	}
+	s = strings.TrimPrefix(strings.ToUpper(s), "AS")
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// ImportASN reads "asn,type" lines (comments start with #) and merges them into the ASN table.
+// Type is one of hosting, isp, mobile, business, education, government or a connection type.
+func (c *Classifier) ImportASN(r io.Reader) (int, error) {
+	entries := make(map[int]string)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxClassifierLineSize)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 {
+			return 0, fmt.Errorf("asn file line %d: expected asn,type", lineNo)
+		}
+		asn, ok := parseASN(fields[0])
+		if !ok {
+			// Tolerate a CSV header row
+			if lineNo == 1 {
+				continue
+			}
+			return 0, fmt.Errorf("asn file line %d: invalid asn %q", lineNo, fields[0])
+		}
+		connType := normalizeConnType(fields[1])
+		if connType == "" {
+			return 0, fmt.Errorf("asn file line %d: unknown type %q", lineNo, fields[1])
+		}
+		entries[asn] = connType
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	for asn, connType := range entries {
+		c.asnTypes[asn] = connType
+	}
+	c.mu.Unlock()
+	return len(entries), nil
+}
+
+// ImportCloudRanges reads "cidr[,provider]" lines and merges them into the cloud range table.
+func (c *Classifier) ImportCloudRanges(r io.Reader) (int, error) {
+	var ranges []cloudRange
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxClassifierLineSize)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ",", 2)
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(fields[0]))
+		if err != nil {
+			if lineNo == 1 {
+				continue
+			}
+			return 0, fmt.Errorf("cloud ranges line %d: %w", lineNo, err)
+		}
+		provider := "cloud"
+		if len(fields) == 2 && strings.TrimSpace(fields[1]) != "" {
+			provider = strings.ToLower(strings.TrimSpace(fields[1]))
+		}
+		ranges = append(ranges, cloudRange{prefix: prefix.Masked(), provider: provider})
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.cloudRanges = append(c.cloudRanges, ranges...)
+	c.mu.Unlock()
+	return len(ranges), nil
+}
+
+// LoadASNFile imports an ASN classification file from disk.
+func (c *Classifier) LoadASNFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return c.ImportASN(f)
+}
+
+// LoadCloudRangesFile imports a cloud range file from disk.
+func (c *Classifier) LoadCloudRangesFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return c.ImportCloudRanges(f)
+}
+
+// Stats reports the size of the loaded lookup tables.
+func (c *Classifier) Stats() map[string]int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return map[string]int{
+		"asn_entries":  len(c.asnTypes),
+		"cloud_ranges": len(c.cloudRanges),
+	}
+}
+
+func (c *Classifier) cloudProvider(ip string) (string, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	addr = addr.Unmap()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, r := range c.cloudRanges {
+		if r.prefix.Contains(addr) {
+			return r.provider, true
+		}
+	}
+	return "", false
+}
+
+// reverseDNS returns the first PTR name of ip, from the cache when it has one.
+// Failed lookups are cached too, unless the caller gave up first.
+func (c *Classifier) reverseDNS(ctx context.Context, ip string) string {
+	now := time.Now()
+	c.rdnsMu.Lock()
+	entry, ok := c.rdnsCache[ip]
+	c.rdnsMu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.hostname
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, rdnsTimeout)
+	defer cancel()
+	names, err := c.lookupAddr(lookupCtx, ip)
+	if err != nil && ctx.Err() != nil {
+		return ""
+	}
+	entry = rdnsEntry{expires: now.Add(rdnsCacheTTL)}
+	if err == nil && len(names) > 0 {
+		entry.hostname = names[0]
+	}
+
+	c.rdnsMu.Lock()
+	defer c.rdnsMu.Unlock()
+	if len(c.rdnsCache) >= rdnsCacheSize {
+		for key, e := range c.rdnsCache {
+			if now.After(e.expires) {
+				delete(c.rdnsCache, key)
+			}
+		}
+		if len(c.rdnsCache) >= rdnsCacheSize {
+			clear(c.rdnsCache)
+		}
+	}
+	c.rdnsCache[ip] = entry
+	return entry.hostname
+}
+
+// Classify combines all available signals into a single connection type. An
+// rDNS lookup, when needed, is bounded by ctx and a short timeout of its own.
+func (c *Classifier) Classify(ctx context.Context, in ClassifyInput) models.ConnectionClass {
+	scores := make(map[string]int)
+	var signals []string
+	vote := func(connType string, weight int, signal string) {
+		scores[connType] += weight
+		signals = append(signals, signal)
+	}
+
+	if provider, ok := c.cloudProvider(in.IP); ok {
+		vote(ConnDatacenter, weightCloudRange, "cloud_range:"+provider)
+	}
+
+	if asn, ok := parseASN(in.ASN); ok {
+		c.mu.RLock()
+		connType, found := c.asnTypes[asn]
+		c.mu.RUnlock()
+		if found {
+			vote(connType, weightASN, fmt.Sprintf("asn:AS%d=%s", asn, connType))
+		}
+	}
+
+	if connType := normalizeConnType(in.ProviderConnectionType); connType != "" {
+		vote(connType, weightProviderType, "provider_connection_type:"+in.ProviderConnectionType)
+	}
+	if in.ProviderMobile {
+		vote(ConnMobile, weightProviderMobile, "provider_mobile")
+	}
+
+	hostname := in.Hostname
+	if hostname == "" && c.ResolveRDNS && c.lookupAddr != nil && in.IP != "" {
+		hostname = c.reverseDNS(ctx, in.IP)
+	}
+	if hostname != "" && hostname != in.IP {
+		if keyword, connType, ok := matchKeyword(hostname, rdnsKeywords); ok {
+			vote(connType, weightRDNS, "rdns:"+keyword)
+		}
+	}
+
+	ispName := strings.TrimSpace(in.ISP + " " + in.Organization)
+	if keyword, connType, ok := matchKeyword(ispName, ispKeywords); ok {
+		vote(connType, weightISPName, "isp_name:"+keyword)
+	}
+
+	if len(scores) == 0 {
+		return models.ConnectionClass{ConnectionType: ConnUnknown}
+	}
+
+	// Deterministic winner: highest score, ties broken by type name
+	types := make([]string, 0, len(scores))
+	total := 0
+	for t, s := range scores {
+		types = append(types, t)
+		total += s
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if scores[types[i]] != scores[types[j]] {
+			return scores[types[i]] > scores[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	winner := types[0]
+
+	denominator := total
+	if denominator < minConfidentWeight {
+		denominator = minConfidentWeight
+	}
+	confidence := math.Round(float64(scores[winner])/float64(denominator)*100) / 100
+
+	return models.ConnectionClass{
+		ConnectionType:       winner,
+		ConnectionConfidence: confidence,
+		ConnectionSignals:    signals,
+	}
+}
+
+// matchKeyword returns the first keyword found as a token (rDNS labels split on
+// '.', '-' and digits; names split on spaces) or as a multi-word phrase.
+func matchKeyword(s string, keywords []struct {
+	keyword  string
+	connType string
+}) (string, string, bool) {
+	lower := strings.ToLower(s)
+	tokens := make(map[string]bool)
+	for _, tok := range strings.FieldsFunc(lower, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z') && !(r >= '0' && r <= '9')
+	}) {
+		tokens[tok] = true
+		// Also index the alphabetic prefix, e.g. "dsl123" -> "dsl"
+		trimmed := strings.TrimRightFunc(tok, func(r rune) bool { return r >= '0' && r <= '9' })
+		if trimmed != "" {
+			tokens[trimmed] = true
+		}
+	}
+	for _, k := range keywords {
+		if strings.Contains(k.keyword, " ") || len(k.keyword) >= 8 {
+			if strings.Contains(lower, k.keyword) {
+				return k.keyword, k.connType, true
+			}
+			continue
+		}
+		if tokens[k.keyword] {
+			return k.keyword, k.connType, true
+		}
+	}
+	return "", "", false
+}
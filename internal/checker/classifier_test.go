@@ -0,0 +1,87 @@
+package checker
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestClassifierASNAndCloud(t *testing.T) {
+	c := NewClassifier()
+	if _, err := c.ImportASN(strings.NewReader("asn,type\n16509,hosting\n7922,isp\n")); err != nil {
+		t.Fatalf("ImportASN failed: %v", err)
+	}
+	if _, err := c.ImportCloudRanges(strings.NewReader("3.0.0.0/8,aws\n")); err != nil {
+		t.Fatalf("ImportCloudRanges failed: %v", err)
+	}
+
+	res := c.Classify(context.Background(), ClassifyInput{IP: "3.4.5.6", ASN: "AS16509"})
+	if res.ConnectionType != ConnDatacenter {
+		t.Errorf("Expected datacenter, got %s", res.ConnectionType)
+	}
+	if res.ConnectionConfidence != 1 {
+		t.Errorf("Expected confidence 1, got %v", res.ConnectionConfidence)
+	}
+	if len(res.ConnectionSignals) != 2 {
+		t.Errorf("Expected 2 signals, got %v", res.ConnectionSignals)
+	}
+
+	res = c.Classify(context.Background(), ClassifyInput{IP: "73.1.2.3", ASN: "AS7922 Comcast", Hostname: "c-73-1-2-3.hsd1.ca.comcast.net"})
+	if res.ConnectionType != ConnResidential {
+		t.Errorf("Expected residential, got %s", res.ConnectionType)
+	}
+}
+
+func TestClassifierProviderHints(t *testing.T) {
+	c := NewClassifier()
+
+	res := c.Classify(context.Background(), ClassifyInput{IP: "10.1.1.1", ProviderConnectionType: "Mobile", ProviderMobile: true})
+	if res.ConnectionType != ConnMobile {
+		t.Errorf("Expected mobile, got %s", res.ConnectionType)
+	}
+
+	res = c.Classify(context.Background(), ClassifyInput{IP: "10.1.1.1", Hostname: "dsl-10-1-1-1.example.net"})
+	if res.ConnectionType != ConnResidential {
+		t.Errorf("Expected residential from rDNS, got %s", res.ConnectionType)
+	}
+	if res.ConnectionConfidence >= 1 {
+		t.Errorf("Single weak signal should not be fully confident, got %v", res.ConnectionConfidence)
+	}
+
+	res = c.Classify(context.Background(), ClassifyInput{IP: "10.1.1.1"})
+	if res.ConnectionType != ConnUnknown {
+		t.Errorf("Expected unknown, got %s", res.ConnectionType)
+	}
+}
+
+func TestClassifierCachesRDNS(t *testing.T) {
+	c := NewClassifier()
+	c.ResolveRDNS = true
+	lookups := 0
+	c.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		lookups++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("rDNS lookup has no deadline")
+		}
+		return []string{"ppp-10-1-1-1.dsl.example.net"}, nil
+	}
+
+	for range 3 {
+		if res := c.Classify(context.Background(), ClassifyInput{IP: "10.1.1.1"}); res.ConnectionType != ConnResidential {
+			t.Fatalf("Expected residential from rDNS, got %s", res.ConnectionType)
+		}
+	}
+	if lookups != 1 {
+		t.Errorf("Expected one cached lookup, got %d", lookups)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.lookupAddr = func(ctx context.Context, addr string) ([]string, error) {
+		return nil, ctx.Err()
+	}
+	c.Classify(ctx, ClassifyInput{IP: "10.2.2.2"})
+	if _, ok := c.rdnsCache["10.2.2.2"]; ok {
+		t.Error("Lookup abandoned by the caller was cached")
+	}
+}
@@ -0,0 +1,156 @@
+package checker
+
+import (
+	"context"
+	"ip-proxy-checker/internal/storage"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// DialLimits caps how hard we hit one proxy host or one subnet. Zero values disable a limit.
+type DialLimits struct {
+	PerHost       int
+	PerSubnet     int
+	HostSpacing   time.Duration // minimum time between two dials to the same host
+	SubnetSpacing time.Duration // minimum time between two dials into the same subnet
+}
+
+func NewDialLimits(cfg *storage.Config) DialLimits {
+	l := cfg.Proxy.DialLimits
+	return DialLimits{
+		PerHost:       l.PerHost,
+		PerSubnet:     l.PerSubnet,
+		HostSpacing:   l.HostSpacing,
+		SubnetSpacing: l.SubnetSpacing,
+	}
+}
+
+// DialLimiter spaces out and caps concurrent dials per proxy host and per
+// subnet (/24 for IPv4, /48 for IPv6), so a list with many ports on one
+// gateway or many IPs in one range does not look like a port scan.
+type DialLimiter struct {
+	limits DialLimits
+
+	mu      sync.Mutex
+	keys    map[string]*dialKey
+	changed chan struct{} // closed whenever a dial slot is released
+}
+
+type dialKey struct {
+	active int
+	next   time.Time // earliest start of the next dial
+}
+
+func NewDialLimiter(limits DialLimits) *DialLimiter {
+	return &DialLimiter{limits: limits, keys: make(map[string]*dialKey), changed: make(chan struct{})}
+}
+
+// subnetOf returns the /24 or /48 of an IP host, or "" for hostnames.
+func subnetOf(host string) string {
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.String()
+}
+
+// prune drops idle keys whose spacing has passed once the map grows large.
+func (d *DialLimiter) prune(now time.Time) {
+	if len(d.keys) < 1024 {
+		return
+	}
+	for key, k := range d.keys {
+		if k.active == 0 && !k.next.After(now) {
+			delete(d.keys, key)
+		}
+	}
+}
+
+// Acquire waits until host may be dialled and returns the function that ends
+// the dial. The spacing delay is served while already holding the slot, so
+// dials to one host start in the order their slots were taken.
+func (d *DialLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	type limit struct {
+		key     string
+		max     int
+		spacing time.Duration
+	}
+	limits := []limit{{"host " + host, d.limits.PerHost, d.limits.HostSpacing}}
+	if subnet := subnetOf(host); subnet != "" {
+		limits = append(limits, limit{"net " + subnet, d.limits.PerSubnet, d.limits.SubnetSpacing})
+	}
+
+	var start time.Time
+	for {
+		d.mu.Lock()
+		full := false
+		for _, l := range limits {
+			k := d.keys[l.key]
+			if k != nil && l.max > 0 && k.active >= l.max {
+				full = true
+			}
+		}
+		if !full {
+			start = time.Now()
+			for _, l := range limits {
+				if k := d.keys[l.key]; k != nil && k.next.After(start) {
+					start = k.next
+				}
+			}
+			for _, l := range limits {
+				k := d.keys[l.key]
+				if k == nil {
+					k = &dialKey{}
+					d.keys[l.key] = k
+				}
+				k.active++
+				k.next = start.Add(l.spacing)
+			}
+			d.prune(time.Now())
+			d.mu.Unlock()
+			break
+		}
+		changed := d.changed
+		d.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	release := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		now := time.Now()
+		for _, l := range limits {
+			k := d.keys[l.key]
+			k.active--
+			if k.active == 0 && !k.next.After(now) {
+				delete(d.keys, l.key)
+			}
+		}
+		close(d.changed)
+		d.changed = make(chan struct{})
+	}
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
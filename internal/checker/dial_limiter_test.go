@@ -0,0 +1,76 @@
+package checker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubnetOf(t *testing.T) {
+	cases := map[string]string{
+		"192.168.1.77":        "192.168.1.0/24",
+		"::ffff:10.0.0.1":     "10.0.0.0/24",
+		"2001:db8:abcd:12::1": "2001:db8:abcd::/48",
+		"gw.example.com":      "",
+	}
+	for host, want := range cases {
+		if got := subnetOf(host); got != want {
+			t.Errorf("subnetOf(%q) = %q, want %q", host, got, want)
+		}
+	}
+}
+
+func TestDialLimiterCapsSubnet(t *testing.T) {
+	d := NewDialLimiter(DialLimits{PerHost: 1, PerSubnet: 2})
+	var mu sync.Mutex
+	active, peak := 0, 0
+	var wg sync.WaitGroup
+	for _, host := range []string{"10.1.1.1", "10.1.1.1", "10.1.1.2", "10.1.1.3", "10.1.2.1"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := d.Acquire(context.Background(), host)
+			if err != nil {
+				t.Errorf("Acquire failed: %v", err)
+				return
+			}
+			if host != "10.1.2.1" {
+				mu.Lock()
+				active++
+				peak = max(peak, active)
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				active--
+				mu.Unlock()
+			}
+			release()
+		}()
+	}
+	wg.Wait()
+	if peak > 2 {
+		t.Errorf("Expected at most 2 dials into 10.1.1.0/24, saw %d", peak)
+	}
+}
+
+func TestDialLimiterSpacing(t *testing.T) {
+	d := NewDialLimiter(DialLimits{HostSpacing: 20 * time.Millisecond})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		release, err := d.Acquire(context.Background(), "gw.example.com")
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		release()
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected dials to be spaced out, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := d.Acquire(ctx, "gw.example.com"); err == nil {
+		t.Errorf("Expected cancelled Acquire to fail while waiting for spacing")
+	}
+}
@@ -0,0 +1,26 @@
+package checker
+
+import "fmt"
+
+// HTTPStatusError is returned when a provider answers with an unexpected status code.
+type HTTPStatusError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPStatusError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("%s bad status code: %d, body: %s", e.Provider, e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("%s bad status code: %d", e.Provider, e.StatusCode)
+}
+
+// panicError carries a panic recovered from a worker or a shared lookup.
+type panicError struct {
+	value interface{}
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
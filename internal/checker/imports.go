@@ -0,0 +1,67 @@
+package checker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"ip-proxy-checker/internal/models"
+	"sync"
+	"time"
+)
+
+// Import is an uploaded list kept until it is handed to a check.
+type Import struct {
+	ID        string
+	Name      string
+	IPs       []string // IP tokens, unexpanded
+	Proxies   []models.ProxyInput
+	CreatedAt time.Time
+}
+
+// Imports keeps parsed uploads for ttl so a check request can refer to them by ID.
+type Imports struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	items map[string]*Import
+}
+
+func NewImports(ttl time.Duration) *Imports {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	return &Imports{ttl: ttl, items: make(map[string]*Import)}
+}
+
+// Add stores a parsed upload under a new unguessable ID.
+func (is *Imports) Add(name string, ips []string, proxies []models.ProxyInput) *Import {
+	var b [8]byte
+	rand.Read(b[:])
+	imp := &Import{
+		ID:        "import-" + hex.EncodeToString(b[:]),
+		Name:      name,
+		IPs:       ips,
+		Proxies:   proxies,
+		CreatedAt: time.Now(),
+	}
+
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for id, old := range is.items {
+		if time.Since(old.CreatedAt) > is.ttl {
+			delete(is.items, id)
+		}
+	}
+	is.items[imp.ID] = imp
+	return imp
+}
+
+// Get returns an import that has not expired yet.
+func (is *Imports) Get(id string) (*Import, bool) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	imp, ok := is.items[id]
+	if !ok || time.Since(imp.CreatedAt) > is.ttl {
+		delete(is.items, id)
+		return nil, false
+	}
+	return imp, true
+}
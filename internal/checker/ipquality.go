@@ -1,6 +1,7 @@
 package checker
 
 import (
+	"context"
 	"fmt"
 	"ip-proxy-checker/internal/models"
 	"ip-proxy-checker/internal/proxy"
@@ -10,17 +11,17 @@ import (
 	"github.com/PuerkitoBio/goquery"
 )
 
-func CheckIPQuality(ip string, proxyClient *proxy.ProxyClient) (*models.IPQualityResult, error) {
+func CheckIPQuality(ctx context.Context, ip string, proxyClient *proxy.ProxyClient) (*models.IPQualityResult, error) {
 	url := fmt.Sprintf("https://www.ipqualityscore.com/free-ip-lookup-proxy-vpn-test/lookup/%s", ip)
 
-	httpClient := http.DefaultClient
+	httpClient := directClient
 	userAgent := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36"
 	if proxyClient != nil {
 		httpClient = proxyClient.HTTPClient
 		userAgent = proxyClient.UserAgent
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -33,7 +34,7 @@ func CheckIPQuality(ip string, proxyClient *proxy.ProxyClient) (*models.IPQualit
 	req.Header.Set("Upgrade-Insecure-Requests", "1")
 	req.Header.Set("Referer", "https://www.google.com/")
 
-	resp, err := httpClient.Do(req)
+	resp, err := doProviderRequest(ctx, ProviderIPQuality, httpClient, req)
 	if err != nil {
 		return &models.IPQualityResult{
 			IP:     ip,
@@ -43,14 +44,10 @@ func CheckIPQuality(ip string, proxyClient *proxy.ProxyClient) (*models.IPQualit
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusForbidden {
-		// FALLBACK TO SCAMALYTICS
-		fmt.Printf("[Fallback] IPQualityScore 403 Forbidden for IP: %s, trying Scamalytics...\n", ip)
-		return CheckScamalytics(ip, proxyClient)
-	}
-
+	// A 403 means the scraper is blocked; the caller's fallback chain moves on
+	// to the next provider, with its own breaker, rate limit and cache key
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status code: %d", resp.StatusCode)
+		return nil, &HTTPStatusError{Provider: ProviderIPQuality, StatusCode: resp.StatusCode}
 	}
 
 	doc, err := goquery.NewDocumentFromReader(resp.Body)
@@ -61,6 +58,7 @@ func CheckIPQuality(ip string, proxyClient *proxy.ProxyClient) (*models.IPQualit
 	result := &models.IPQualityResult{
 		IP:     ip,
 		Status: "Live",
+		Source: ProviderIPQuality,
 	}
 
 	// Targeted extraction from the table structure
@@ -86,7 +84,10 @@ func CheckIPQuality(ip string, proxyClient *proxy.ProxyClient) (*models.IPQualit
 			if row4.Length() >= 5 {
 				result.ISP = cleanValue(row4.Eq(0).Text())
 				result.Organization = cleanValue(row4.Eq(1).Text())
-				// We could add Hostname/ASN to models if needed, for now we match existing fields
+				result.Hostname = cleanValue(row4.Eq(2).Text())
+				if asn := cleanValue(row4.Eq(3).Text()); asn != "" && asn != "N/A" {
+					result.ASN = asn
+				}
 			}
 		}
 	}
@@ -1,6 +1,7 @@
 package checker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -11,23 +12,26 @@ import (
 )
 
 type IPQualityAPIResponse struct {
-	Success      bool   `json:"success"`
-	Message      string `json:"message"`
-	FraudScore   int    `json:"fraud_score"`
-	CountryCode  string `json:"country_code"`
-	Region       string `json:"region"`
-	City         string `json:"city"`
-	ISP          string `json:"ISP"`
-	ASN          int    `json:"ASN"`
-	Organization string `json:"organization"`
-	Proxy        bool   `json:"proxy"`
-	VPN          bool   `json:"vpn"`
-	TOR          bool   `json:"tor"`
-	ActiveVPN    bool   `json:"active_vpn"`
-	ActiveTOR    bool   `json:"active_tor"`
+	Success        bool   `json:"success"`
+	Message        string `json:"message"`
+	FraudScore     int    `json:"fraud_score"`
+	CountryCode    string `json:"country_code"`
+	Region         string `json:"region"`
+	City           string `json:"city"`
+	ISP            string `json:"ISP"`
+	ASN            int    `json:"ASN"`
+	Host           string `json:"host"`
+	Organization   string `json:"organization"`
+	Proxy          bool   `json:"proxy"`
+	VPN            bool   `json:"vpn"`
+	TOR            bool   `json:"tor"`
+	ActiveVPN      bool   `json:"active_vpn"`
+	ActiveTOR      bool   `json:"active_tor"`
+	Mobile         bool   `json:"mobile"`
+	ConnectionType string `json:"connection_type"`
 }
 
-func CheckIPQualityAPI(apiKey string, ip string, proxyClient *proxy.ProxyClient) (*models.IPQualityResult, error) {
+func CheckIPQualityAPI(ctx context.Context, apiKey string, ip string, proxyClient *proxy.ProxyClient) (*models.IPQualityResult, error) {
 	baseURL := fmt.Sprintf("https://ipqualityscore.com/api/json/ip/%s/%s", apiKey, ip)
 
 	// Add parameters
@@ -37,26 +41,26 @@ func CheckIPQualityAPI(apiKey string, ip string, proxyClient *proxy.ProxyClient)
 	params.Add("lighter_penalties", "true")
 	fullURL := fmt.Sprintf("%s?%s", baseURL, params.Encode())
 
-	httpClient := http.DefaultClient
+	httpClient := directClient
 	if proxyClient != nil {
 		httpClient = proxyClient.HTTPClient
 	}
 
-	req, err := http.NewRequest("GET", fullURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fullURL, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", "IPQualityScore-Go-Client/1.0")
 
-	resp, err := httpClient.Do(req)
+	resp, err := doProviderRequest(ctx, ProviderIPQualityAPI, httpClient, req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API error: status %d, body: %s", resp.StatusCode, string(body))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &HTTPStatusError{Provider: ProviderIPQualityAPI, StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var apiResp IPQualityAPIResponse
@@ -76,10 +80,17 @@ func CheckIPQualityAPI(apiKey string, ip string, proxyClient *proxy.ProxyClient)
 		Region:       apiResp.Region,
 		ISP:          apiResp.ISP,
 		Organization: apiResp.Organization,
+		Hostname:     apiResp.Host,
 		FraudScore:   fmt.Sprintf("%d", apiResp.FraudScore),
 		VPN:          apiResp.VPN || apiResp.ActiveVPN,
 		Proxy:        apiResp.Proxy,
-		Error:        "(Source: Official API)",
+		Source:       ProviderIPQualityAPI,
+
+		ProviderConnectionType: apiResp.ConnectionType,
+		Mobile:                 apiResp.Mobile,
+	}
+	if apiResp.ASN > 0 {
+		result.ASN = fmt.Sprintf("AS%d", apiResp.ASN)
 	}
 
 	return result, nil
@@ -1,11 +1,11 @@
 package checker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"ip-proxy-checker/internal/models"
 	"net/http"
-	"time"
 )
 
 type IPWhoResponse struct {
@@ -29,32 +29,42 @@ type IPWhoResponse struct {
 	Message string `json:"message"`
 }
 
-func CheckIPWho(ip string) (*models.WhoisResult, error) {
+func CheckIPWho(ctx context.Context, ip string) (*models.WhoisResult, error) {
 	url := fmt.Sprintf("https://ipwho.is/%s", ip)
-	client := &http.Client{Timeout: 30 * time.Second}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := doProviderRequest(ctx, ProviderIPWho, directClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	resp, err := client.Get(url)
-	if err == nil {
-		defer resp.Body.Close()
-		var apiResp IPWhoResponse
-		if err := json.NewDecoder(resp.Body).Decode(&apiResp); err == nil && apiResp.Success {
-			return &models.WhoisResult{
-				IP:          apiResp.IP,
-				Country:     apiResp.Country,
-				CountryCode: apiResp.CountryCode,
-				Region:      apiResp.Region,
-				City:        apiResp.City,
-				Flag:        apiResp.Flag.Img,
-				ISP:         apiResp.Connection.ISP,
-				ASN:         fmt.Sprintf("AS%d", apiResp.Connection.ASN),
-				Timezone:    apiResp.Timezone.Utc,
-				Status:      "success",
-			}, nil
-		}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPStatusError{Provider: ProviderIPWho, StatusCode: resp.StatusCode}
 	}
 
-	// Fallback to ip-api.com
-	return CheckIPApi(ip)
+	var apiResp IPWhoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, err
+	}
+	if !apiResp.Success {
+		return nil, fmt.Errorf("ipwho failed: %s", apiResp.Message)
+	}
+
+	return &models.WhoisResult{
+		IP:          apiResp.IP,
+		Country:     apiResp.Country,
+		CountryCode: apiResp.CountryCode,
+		Region:      apiResp.Region,
+		City:        apiResp.City,
+		Flag:        apiResp.Flag.Img,
+		ISP:         apiResp.Connection.ISP,
+		ASN:         fmt.Sprintf("AS%d", apiResp.Connection.ASN),
+		Timezone:    apiResp.Timezone.Utc,
+		Status:      "success",
+	}, nil
 }
 
 type IPApiResponse struct {
@@ -69,16 +79,23 @@ type IPApiResponse struct {
 	Timezone    string `json:"timezone"`
 }
 
-func CheckIPApi(ip string) (*models.WhoisResult, error) {
+func CheckIPApi(ctx context.Context, ip string) (*models.WhoisResult, error) {
 	url := fmt.Sprintf("http://ip-api.com/json/%s", ip) // Free tier uses HTTP
-	client := &http.Client{Timeout: 30 * time.Second}
-
-	resp, err := client.Get(url)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return &models.WhoisResult{IP: ip, Status: "failed", Error: err.Error()}, err
+	}
+	resp, err := doProviderRequest(ctx, ProviderIPApi, directClient, req)
 	if err != nil {
 		return &models.WhoisResult{IP: ip, Status: "failed", Error: err.Error()}, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		err := &HTTPStatusError{Provider: ProviderIPApi, StatusCode: resp.StatusCode}
+		return &models.WhoisResult{IP: ip, Status: "failed", Error: err.Error()}, err
+	}
+
 	var apiResp IPApiResponse
 	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
 		return &models.WhoisResult{IP: ip, Status: "failed", Error: err.Error()}, err
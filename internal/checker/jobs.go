@@ -0,0 +1,183 @@
+package checker
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job tracks the progress of one check request, and its results once it finished.
+type Job struct {
+	ID        string
+	Kind      string
+	Tenant    string
+	Priority  string
+	Total     int
+	StartedAt time.Time
+
+	done atomic.Int64
+	size int
+	ctrl *AIMD
+
+	// Set by Jobs.Finish
+	finishedAt time.Time
+	results    any
+}
+
+type JobProgress struct {
+	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
+	Tenant      string     `json:"tenant"`
+	Priority    string     `json:"priority"`
+	Total       int        `json:"total"`
+	Done        int64      `json:"done"`
+	Concurrency int        `json:"concurrency"`
+	State       string     `json:"state"` // running or finished
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+}
+
+// Advance records one finished item.
+func (j *Job) Advance() {
+	j.done.Add(1)
+}
+
+// Concurrency is the job's fixed pool size, or the current limit of the
+// controller shared by every job.
+func (j *Job) Concurrency() int {
+	if j.ctrl != nil {
+		return j.ctrl.Limit()
+	}
+	return j.size
+}
+
+// progress must be called with the registry lock held.
+func (j *Job) progress() JobProgress {
+	p := JobProgress{
+		ID:          j.ID,
+		Kind:        j.Kind,
+		Tenant:      j.Tenant,
+		Priority:    j.Priority,
+		Total:       j.Total,
+		Done:        j.done.Load(),
+		Concurrency: j.Concurrency(),
+		State:       "running",
+		StartedAt:   j.StartedAt,
+	}
+	if !j.finishedAt.IsZero() {
+		p.State = "finished"
+		p.FinishedAt = &j.finishedAt
+	}
+	return p
+}
+
+// Jobs is the registry of running check jobs. Finished jobs and their
+// results are kept for the retention period.
+type Jobs struct {
+	mu        sync.Mutex
+	seq       int64
+	retention time.Duration
+	running   map[string]*Job
+	finished  map[string]*Job
+}
+
+func NewJobs(retention time.Duration) *Jobs {
+	return &Jobs{retention: retention, running: make(map[string]*Job), finished: make(map[string]*Job)}
+}
+
+// Start registers a job running with a fixed pool size, or under the shared ctrl when it is not nil.
+func (js *Jobs) Start(kind, tenant, priority string, total, size int, ctrl *AIMD) *Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	js.seq++
+	j := &Job{
+		ID:        fmt.Sprintf("%s-%d", kind, js.seq),
+		Kind:      kind,
+		Tenant:    tenant,
+		Priority:  priority,
+		Total:     total,
+		StartedAt: time.Now(),
+		size:      size,
+		ctrl:      ctrl,
+	}
+	js.running[j.ID] = j
+	return j
+}
+
+// Finish marks a job finished and keeps its results for the retention period.
+func (js *Jobs) Finish(j *Job, results any) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	delete(js.running, j.ID)
+	j.finishedAt, j.results = time.Now(), results
+
+	for id, old := range js.finished {
+		if time.Since(old.finishedAt) > js.retention {
+			delete(js.finished, id)
+		}
+	}
+	if js.retention > 0 {
+		js.finished[j.ID] = j
+	}
+}
+
+var (
+	ErrJobNotFound = errors.New("job not found or expired")
+	ErrJobRunning  = errors.New("job is still running")
+)
+
+// Results returns a finished job and the results it stored.
+func (js *Jobs) Results(id string) (*Job, any, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	if _, ok := js.running[id]; ok {
+		return nil, nil, ErrJobRunning
+	}
+	j, ok := js.finished[id]
+	if !ok || time.Since(j.finishedAt) > js.retention {
+		return nil, nil, ErrJobNotFound
+	}
+	return j, j.results, nil
+}
+
+// Progress lists the running and retained finished jobs, oldest first.
+func (js *Jobs) Progress() []JobProgress {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	list := make([]JobProgress, 0, len(js.running)+len(js.finished))
+	for _, j := range js.running {
+		list = append(list, j.progress())
+	}
+	for _, j := range js.finished {
+		if time.Since(j.finishedAt) <= js.retention {
+			list = append(list, j.progress())
+		}
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].StartedAt.Before(list[k].StartedAt) })
+	return list
+}
+
+// FinishedResults returns the results of the retained finished jobs of kind,
+// in the order they finished.
+func (js *Jobs) FinishedResults(kind string) []any {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	var jobs []*Job
+	for _, j := range js.finished {
+		if j.Kind == kind && time.Since(j.finishedAt) <= js.retention {
+			jobs = append(jobs, j)
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].finishedAt.Before(jobs[k].finishedAt) })
+	results := make([]any, len(jobs))
+	for i, j := range jobs {
+		results[i] = j.results
+	}
+	return results
+}
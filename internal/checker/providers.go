@@ -0,0 +1,312 @@
+package checker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"ip-proxy-checker/internal/models"
+	"ip-proxy-checker/internal/proxy"
+	"ip-proxy-checker/internal/storage"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ProviderIPWho        = "ipwho"
+	ProviderIPApi        = "ipapi"
+	ProviderIPQuality    = "ipquality"
+	ProviderIPQualityAPI = "ipquality_api"
+	ProviderScamalytics  = "scamalytics"
+	ProviderAbuseIPDB    = "abuseipdb"
+)
+
+// LookupOptions are per-request overrides of the provider cache.
+type LookupOptions struct {
+	MaxAge  time.Duration // ignore cached results older than this; 0 means the provider TTL
+	NoCache bool          // bypass cache reads; fresh results are still stored
+	Stats   *LookupStats  // optional per-request counters
+}
+
+// LookupStats counts how provider lookups were served. Safe for concurrent use.
+type LookupStats struct {
+	networkCalls atomic.Int64
+	cacheHits    atomic.Int64
+	callsSaved   atomic.Int64
+	breakerSkips atomic.Int64
+}
+
+func (s *LookupStats) Summary(total int) models.CheckSummary {
+	return models.CheckSummary{
+		Total:        total,
+		NetworkCalls: s.networkCalls.Load(),
+		CacheHits:    s.cacheHits.Load(),
+		CallsSaved:   s.callsSaved.Load(),
+		BreakerSkips: s.breakerSkips.Load(),
+	}
+}
+
+func (opts LookupOptions) count(f func(*LookupStats)) {
+	if opts.Stats != nil {
+		f(opts.Stats)
+	}
+}
+
+type cacheMarker interface {
+	MarkCached(age time.Duration)
+}
+
+type attemptRecorder interface {
+	RecordAttempts(step string, attempts int, errs []error)
+}
+
+// Providers wraps the individual provider checks with shared cross-request state.
+type Providers struct {
+	cache    *storage.Cache
+	ttls     map[string]time.Duration
+	timeouts map[string]time.Duration
+	retry    RetryPolicy
+	breakers map[string]*CircuitBreaker
+	flights  flightGroup
+	stats    LookupStats
+}
+
+// providerConfigs maps every provider to its section in config.yaml.
+func providerConfigs(cfg *storage.Config) map[string]storage.ProviderConfig {
+	return map[string]storage.ProviderConfig{
+		ProviderIPWho:        cfg.API.IPWho,
+		ProviderIPApi:        cfg.API.IPApi,
+		ProviderIPQuality:    cfg.API.IPQuality.ProviderConfig,
+		ProviderIPQualityAPI: cfg.API.IPQuality.ProviderConfig,
+		ProviderScamalytics:  cfg.API.Scamalytics,
+		ProviderAbuseIPDB:    cfg.API.AbuseIPDB,
+	}
+}
+
+// NewProviders builds the provider layer and configures the process-wide
+// provider rate limiters. A nil cache disables caching.
+func NewProviders(cfg *storage.Config, cache *storage.Cache) *Providers {
+	if !cfg.Storage.CacheEnabled {
+		cache = nil
+	}
+	p := &Providers{
+		cache:    cache,
+		ttls:     make(map[string]time.Duration),
+		timeouts: make(map[string]time.Duration),
+		retry:    NewRetryPolicy(cfg),
+		breakers: make(map[string]*CircuitBreaker),
+	}
+	for name, pc := range providerConfigs(cfg) {
+		p.ttls[name] = pc.CacheTTL
+		p.timeouts[name] = pc.Timeout
+		ConfigureRateLimit(name, pc.RateLimit, pc.Burst)
+
+		threshold, cooldown := cfg.CircuitBreaker.FailureThreshold, cfg.CircuitBreaker.Cooldown
+		if pc.BreakerThreshold != 0 {
+			threshold = pc.BreakerThreshold
+		}
+		if pc.BreakerCooldown != 0 {
+			cooldown = pc.BreakerCooldown
+		}
+		p.breakers[name] = NewCircuitBreaker(threshold, cooldown)
+	}
+	return p
+}
+
+func cacheKey(provider, ip string) string {
+	return provider + ":" + ip
+}
+
+// keyScope identifies an API key in flight keys without keeping the key itself.
+func keyScope(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// lookup serves a provider result from cache when allowed, otherwise fetches it
+// (retrying transient errors) and stores it if usable reports the result is
+// worth caching. Concurrent lookups of the same (provider, IP) share a single
+// fetch; scope separates lookups that must not share one, e.g. those made
+// with different API keys. Proxied lookups never share a fetch with direct
+// ones, and only their successes and provider-side errors (5xx, 429) feed
+// the circuit breaker: a dead proxy or a banned exit IP says nothing about
+// the provider.
+//
+// network_calls counts the requests actually sent, retries included, on the
+// process counters and on the stats of the lookup that sent them.
+func lookup[T any](ctx context.Context, p *Providers, provider, ip, scope string, proxied bool, opts LookupOptions, fetch func(ctx context.Context) (*T, error), usable func(*T) bool) (*T, error) {
+	key := cacheKey(provider, ip)
+	flightKey := key
+	if scope != "" {
+		flightKey += ":" + scope
+	}
+	if proxied {
+		flightKey += ":proxied"
+	}
+	ttl := p.ttls[provider]
+	cacheable := p.cache != nil && ttl > 0
+
+	if cacheable && !opts.NoCache {
+		if entry, ok := p.cache.GetEntry(key); ok {
+			age := time.Since(entry.CreatedAt)
+			if opts.MaxAge <= 0 || age <= opts.MaxAge {
+				var cached T
+				if err := json.Unmarshal([]byte(entry.Value), &cached); err == nil {
+					if m, ok := any(&cached).(cacheMarker); ok {
+						m.MarkCached(age)
+					}
+					p.stats.cacheHits.Add(1)
+					opts.count(func(s *LookupStats) { s.cacheHits.Add(1) })
+					return &cached, nil
+				}
+			}
+		}
+	}
+
+	breaker := p.breakers[provider]
+	if breaker != nil && !breaker.Allow() {
+		p.stats.breakerSkips.Add(1)
+		opts.count(func(s *LookupStats) { s.breakerSkips.Add(1) })
+		return nil, circuitOpenError(provider)
+	}
+
+	timeout := p.timeouts[provider]
+	val, err, shared := p.flights.Do(ctx, flightKey, func(ctx context.Context) (interface{}, error) {
+		var res *T
+		attempts, errs, err := p.retry.Do(ctx, func() error {
+			p.stats.networkCalls.Add(1)
+			opts.count(func(s *LookupStats) { s.networkCalls.Add(1) })
+			attemptCtx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			var err error
+			res, err = fetch(attemptCtx)
+			return err
+		})
+		if breaker != nil {
+			switch {
+			case errors.Is(err, context.Canceled):
+				// Every caller gave up: no evidence either way
+				breaker.Cancel()
+			case err == nil:
+				breaker.Success()
+			case !proxied || providerSide(err):
+				breaker.Failure(err)
+			default:
+				breaker.Cancel()
+			}
+		}
+		if err == nil && res != nil && cacheable && usable(res) {
+			p.cache.SetEntry(key, provider, ip, res, ttl)
+		}
+		if r, ok := any(res).(attemptRecorder); ok && res != nil {
+			r.RecordAttempts(provider, attempts, errs)
+		}
+		return res, err
+	})
+	if shared {
+		p.stats.callsSaved.Add(1)
+		opts.count(func(s *LookupStats) { s.callsSaved.Add(1) })
+	}
+
+	res, _ := val.(*T)
+	if res == nil {
+		if err == nil {
+			err = errors.New(provider + ": lookup returned no result")
+		}
+		return nil, err
+	}
+	// Callers modify their result, so every waiter gets its own copy
+	cp := *res
+	return &cp, err
+}
+
+// providerSide reports whether err shows the provider itself failing.
+func providerSide(err error) bool {
+	var statusErr *HTTPStatusError
+	return errors.As(err, &statusErr) && (statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests)
+}
+
+// BreakerStatuses reports the circuit breaker of every provider.
+func (p *Providers) BreakerStatuses() map[string]BreakerStatus {
+	statuses := make(map[string]BreakerStatus, len(p.breakers))
+	for name, b := range p.breakers {
+		statuses[name] = b.Status()
+	}
+	return statuses
+}
+
+// Summary builds the summary of a check from its counters and the current breaker states.
+func (p *Providers) Summary(stats *LookupStats, total int) models.CheckSummary {
+	summary := stats.Summary(total)
+	summary.Breakers = make(map[string]string, len(p.breakers))
+	for name, b := range p.breakers {
+		summary.Breakers[name] = b.Status().State
+	}
+	return summary
+}
+
+// Stats returns the process-wide lookup counters.
+func (p *Providers) Stats() models.CheckSummary {
+	summary := p.stats.Summary(0)
+	summary.Total = int(summary.NetworkCalls + summary.CacheHits + summary.CallsSaved)
+	return summary
+}
+
+func whoisUsable(res *models.WhoisResult) bool {
+	return res.Status == "success"
+}
+
+func qualityUsable(res *models.IPQualityResult) bool {
+	return res.FraudScore != ""
+}
+
+func (p *Providers) IPWho(ctx context.Context, ip string, opts LookupOptions) (*models.WhoisResult, error) {
+	return lookup(ctx, p, ProviderIPWho, ip, "", false, opts, func(ctx context.Context) (*models.WhoisResult, error) {
+		return CheckIPWho(ctx, ip)
+	}, whoisUsable)
+}
+
+func (p *Providers) IPApi(ctx context.Context, ip string, opts LookupOptions) (*models.WhoisResult, error) {
+	return lookup(ctx, p, ProviderIPApi, ip, "", false, opts, func(ctx context.Context) (*models.WhoisResult, error) {
+		return CheckIPApi(ctx, ip)
+	}, whoisUsable)
+}
+
+// Whois queries ipwho.is and falls back to ip-api.com.
+func (p *Providers) Whois(ctx context.Context, ip string, opts LookupOptions) (*models.WhoisResult, error) {
+	if res, err := p.IPWho(ctx, ip, opts); err == nil {
+		return res, nil
+	}
+	return p.IPApi(ctx, ip, opts)
+}
+
+func (p *Providers) IPQualityAPI(ctx context.Context, apiKey, ip string, proxyClient *proxy.ProxyClient, opts LookupOptions) (*models.IPQualityResult, error) {
+	return lookup(ctx, p, ProviderIPQualityAPI, ip, keyScope(apiKey), proxyClient != nil, opts, func(ctx context.Context) (*models.IPQualityResult, error) {
+		return CheckIPQualityAPI(ctx, apiKey, ip, proxyClient)
+	}, qualityUsable)
+}
+
+func (p *Providers) IPQuality(ctx context.Context, ip string, proxyClient *proxy.ProxyClient, opts LookupOptions) (*models.IPQualityResult, error) {
+	return lookup(ctx, p, ProviderIPQuality, ip, "", proxyClient != nil, opts, func(ctx context.Context) (*models.IPQualityResult, error) {
+		return CheckIPQuality(ctx, ip, proxyClient)
+	}, qualityUsable)
+}
+
+func (p *Providers) Scamalytics(ctx context.Context, ip string, proxyClient *proxy.ProxyClient, opts LookupOptions) (*models.IPQualityResult, error) {
+	return lookup(ctx, p, ProviderScamalytics, ip, "", proxyClient != nil, opts, func(ctx context.Context) (*models.IPQualityResult, error) {
+		return CheckScamalytics(ctx, ip, proxyClient)
+	}, qualityUsable)
+}
+
+func (p *Providers) AbuseIPDB(ctx context.Context, ip string, proxyClient *proxy.ProxyClient, opts LookupOptions) (*models.IPQualityResult, error) {
+	return lookup(ctx, p, ProviderAbuseIPDB, ip, "", proxyClient != nil, opts, func(ctx context.Context) (*models.IPQualityResult, error) {
+		return CheckAbuseIPDB(ctx, ip, proxyClient)
+	}, qualityUsable)
+}
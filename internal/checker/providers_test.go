@@ -0,0 +1,113 @@
+package checker
+
+import (
+	"context"
+	"io"
+	"ip-proxy-checker/internal/models"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLookupCoalescesConcurrentCalls(t *testing.T) {
+	p := &Providers{ttls: map[string]time.Duration{}}
+	stats := &LookupStats{}
+	opts := LookupOptions{Stats: stats}
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (*models.WhoisResult, error) {
+		fetches.Add(1)
+		<-release
+		return &models.WhoisResult{IP: "1.1.1.1", Status: "success"}, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*models.WhoisResult, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = lookup(context.Background(), p, ProviderIPWho, "1.1.1.1", "", false, opts, fetch, whoisUsable)
+		}(i)
+	}
+
+	// Give the waiters time to join the in-flight call
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("Expected 1 fetch, got %d", n)
+	}
+	summary := stats.Summary(len(results))
+	if summary.NetworkCalls != 1 || summary.CallsSaved != 4 {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+
+	results[0].City = "changed"
+	if results[1].City != "" {
+		t.Errorf("Waiters must receive independent copies")
+	}
+}
+
+func TestLookupFlightKeepsDeadlineAndAPIKey(t *testing.T) {
+	p := &Providers{ttls: map[string]time.Duration{}}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	want, _ := ctx.Deadline()
+	var got time.Time
+	lookup(ctx, p, ProviderIPWho, "1.1.1.1", "", false, LookupOptions{}, func(ctx context.Context) (*models.WhoisResult, error) {
+		got, _ = ctx.Deadline()
+		return &models.WhoisResult{Status: "success"}, nil
+	}, whoisUsable)
+	if !got.Equal(want) {
+		t.Errorf("Shared fetch deadline = %v, want the caller's %v", got, want)
+	}
+
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, key := range []string{"key-a", "key-b"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lookup(context.Background(), p, ProviderIPQualityAPI, "1.1.1.1", keyScope(key), false, LookupOptions{}, func(ctx context.Context) (*models.IPQualityResult, error) {
+				fetches.Add(1)
+				<-release
+				return &models.IPQualityResult{}, nil
+			}, qualityUsable)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("Lookups with different API keys shared a fetch: %d fetches", n)
+	}
+}
+
+func TestLookupBreakerIgnoresProxyFailures(t *testing.T) {
+	breaker := NewCircuitBreaker(2, time.Hour)
+	p := &Providers{ttls: map[string]time.Duration{}, breakers: map[string]*CircuitBreaker{ProviderIPQuality: breaker}}
+	fail := func(err error) func(context.Context) (*models.IPQualityResult, error) {
+		return func(context.Context) (*models.IPQualityResult, error) { return nil, err }
+	}
+
+	// A dead proxy and a banned exit IP are the proxy's fault
+	for _, err := range []error{io.EOF, &HTTPStatusError{Provider: ProviderIPQuality, StatusCode: 403}, io.EOF} {
+		lookup(context.Background(), p, ProviderIPQuality, "1.1.1.1", "", true, LookupOptions{}, fail(err), qualityUsable)
+	}
+	if st := breaker.Status(); st.State != BreakerClosed {
+		t.Fatalf("Proxy failures opened the breaker: %+v", st)
+	}
+
+	for range 2 {
+		lookup(context.Background(), p, ProviderIPQuality, "1.1.1.1", "", true, LookupOptions{}, fail(&HTTPStatusError{Provider: ProviderIPQuality, StatusCode: 503}), qualityUsable)
+	}
+	if st := breaker.Status(); st.State != BreakerOpen {
+		t.Errorf("Provider 503s through proxies did not open the breaker: %+v", st)
+	}
+}
@@ -1,29 +1,234 @@
 package checker
 
 import (
+	"context"
+	"net/http"
+	"strconv"
 	"sync"
 	"time"
 )
 
+// defaultThrottlePause is used when a provider answers 429 without telling us how long to wait.
+const defaultThrottlePause = 30 * time.Second
+
+// RateLimiter is a token bucket with an optional pause set from provider responses.
 type RateLimiter struct {
 	mu          sync.Mutex
-	lastRequest time.Time
-	minInterval time.Duration
+	rate        float64 // tokens per second, <= 0 means unlimited
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	pauseReason string
+}
+
+type RateLimiterState struct {
+	Rate        float64    `json:"rate"`
+	Burst       int        `json:"burst"`
+	Tokens      float64    `json:"tokens"`
+	PausedUntil *time.Time `json:"paused_until,omitempty"`
+	PauseReason string     `json:"pause_reason,omitempty"`
 }
 
-func NewRateLimiter(reqPerSecond int) *RateLimiter {
+func NewRateLimiter(reqPerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
 	return &RateLimiter{
-		minInterval: time.Second / time.Duration(reqPerSecond),
+		rate:   reqPerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a request may be sent or ctx is done.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	d := rl.reserve()
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token, possibly going into debt, and returns how long the caller must wait.
+func (rl *RateLimiter) reserve() time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	if rl.rate > 0 {
+		rl.tokens += now.Sub(rl.last).Seconds() * rl.rate
+		if rl.tokens > rl.burst {
+			rl.tokens = rl.burst
+		}
+		rl.last = now
+		rl.tokens--
+		if rl.tokens < 0 {
+			wait = time.Duration(-rl.tokens / rl.rate * float64(time.Second))
+		}
+	}
+	if pause := rl.pausedUntil.Sub(now); pause > wait {
+		wait = pause
+	}
+	return wait
+}
+
+// Pause stops the limiter from handing out tokens for d.
+func (rl *RateLimiter) Pause(d time.Duration, reason string) {
+	if d <= 0 {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(rl.pausedUntil) {
+		rl.pausedUntil = until
+		rl.pauseReason = reason
+		// Start from an empty bucket once the pause is over
+		if rl.tokens > 0 {
+			rl.tokens = 0
+		}
+	}
+}
+
+// Observe pauses the limiter according to the rate limit headers of a provider response.
+func (rl *RateLimiter) Observe(resp *http.Response) {
+	if d, reason, ok := throttleFromResponse(resp); ok {
+		rl.Pause(d, reason)
 	}
 }
 
-func (rl *RateLimiter) Wait() {
+func (rl *RateLimiter) State() RateLimiterState {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	elapsed := time.Since(rl.lastRequest)
-	if elapsed < rl.minInterval {
-		time.Sleep(rl.minInterval - elapsed)
+	state := RateLimiterState{Rate: rl.rate, Burst: int(rl.burst), Tokens: rl.tokens}
+	if rl.rate > 0 {
+		state.Tokens = rl.tokens + time.Since(rl.last).Seconds()*rl.rate
+		if state.Tokens > rl.burst {
+			state.Tokens = rl.burst
+		}
+	}
+	if time.Now().Before(rl.pausedUntil) {
+		until := rl.pausedUntil
+		state.PausedUntil = &until
+		state.PauseReason = rl.pauseReason
+	}
+	return state
+}
+
+// throttleFromResponse understands Retry-After, ip-api's X-Rl/X-Ttl and the
+// common X-RateLimit-Remaining/X-RateLimit-Reset pair.
+func throttleFromResponse(resp *http.Response) (time.Duration, string, bool) {
+	h := resp.Header
+	if v := h.Get("Retry-After"); v != "" {
+		if d, ok := parseRetryAfter(v); ok {
+			return d, "Retry-After " + v, true
+		}
+	}
+	if h.Get("X-Rl") == "0" {
+		if secs, err := strconv.Atoi(h.Get("X-Ttl")); err == nil {
+			return time.Duration(secs) * time.Second, "X-Rl exhausted", true
+		}
+	}
+	if h.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			d := time.Duration(reset) * time.Second
+			// Large values are a Unix timestamp rather than a delay
+			if reset > 1_000_000_000 {
+				d = time.Until(time.Unix(reset, 0))
+			}
+			return d, "X-RateLimit exhausted", true
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return defaultThrottlePause, "429 Too Many Requests", true
+	}
+	return 0, "", false
+}
+
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
+
+// rateLimiters holds one limiter per provider, shared by every worker pool in the process.
+type rateLimiters struct {
+	mu       sync.RWMutex
+	limiters map[string]*RateLimiter
+}
+
+var limiters = &rateLimiters{limiters: make(map[string]*RateLimiter)}
+
+// ConfigureRateLimit sets the token bucket for a provider. A rate <= 0 disables
+// throttling but still honours pauses requested by the provider.
+func ConfigureRateLimit(provider string, reqPerSecond float64, burst int) {
+	limiters.mu.Lock()
+	defer limiters.mu.Unlock()
+	limiters.limiters[provider] = NewRateLimiter(reqPerSecond, burst)
+}
+
+func limiterFor(provider string) *RateLimiter {
+	limiters.mu.RLock()
+	rl, ok := limiters.limiters[provider]
+	limiters.mu.RUnlock()
+	if ok {
+		return rl
+	}
+
+	limiters.mu.Lock()
+	defer limiters.mu.Unlock()
+	if rl, ok = limiters.limiters[provider]; !ok {
+		rl = NewRateLimiter(0, 1)
+		limiters.limiters[provider] = rl
+	}
+	return rl
+}
+
+// RateLimitStates reports the current state of every provider limiter.
+func RateLimitStates() map[string]RateLimiterState {
+	limiters.mu.RLock()
+	defer limiters.mu.RUnlock()
+	states := make(map[string]RateLimiterState, len(limiters.limiters))
+	for name, rl := range limiters.limiters {
+		states[name] = rl.State()
+	}
+	return states
+}
+
+// directClient is used for provider calls that do not go through a proxy.
+// Callers bound each request with their context; the timeout is a backstop.
+var directClient = &http.Client{Timeout: 60 * time.Second}
+
+// doProviderRequest sends a provider request through that provider's limiter.
+// Only direct responses pause the limiter: a 429 through a proxy limits that
+// exit IP, not this host.
+func doProviderRequest(ctx context.Context, provider string, client *http.Client, req *http.Request) (*http.Response, error) {
+	rl := limiterFor(provider)
+	if err := rl.Wait(ctx); err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if client == directClient {
+		rl.Observe(resp)
 	}
-	rl.lastRequest = time.Now()
+	return resp, nil
 }
@@ -0,0 +1,104 @@
+package checker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThrottleFromResponse(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		header map[string]string
+		want   time.Duration
+		ok     bool
+	}{
+		{"retry-after seconds", 429, map[string]string{"Retry-After": "12"}, 12 * time.Second, true},
+		{"ip-api exhausted", 200, map[string]string{"X-Rl": "0", "X-Ttl": "40"}, 40 * time.Second, true},
+		{"ip-api remaining", 200, map[string]string{"X-Rl": "10", "X-Ttl": "40"}, 0, false},
+		{"ratelimit reset", 200, map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": "5"}, 5 * time.Second, true},
+		{"bare 429", 429, nil, defaultThrottlePause, true},
+		{"ok", 200, nil, 0, false},
+	}
+	for _, c := range cases {
+		resp := &http.Response{StatusCode: c.status, Header: http.Header{}}
+		for k, v := range c.header {
+			resp.Header.Set(k, v)
+		}
+		d, _, ok := throttleFromResponse(resp)
+		if ok != c.ok || d != c.want {
+			t.Errorf("%s: got (%v, %v), want (%v, %v)", c.name, d, ok, c.want, c.ok)
+		}
+	}
+}
+
+func TestRateLimiterBurstAndPause(t *testing.T) {
+	rl := NewRateLimiter(10, 2)
+	if rl.reserve() != 0 || rl.reserve() != 0 {
+		t.Fatalf("Burst tokens should be available immediately")
+	}
+	if d := rl.reserve(); d <= 0 || d > 100*time.Millisecond {
+		t.Errorf("Third request should wait about 100ms, got %v", d)
+	}
+
+	rl.Pause(time.Second, "test")
+	if d := rl.reserve(); d < 900*time.Millisecond {
+		t.Errorf("Paused limiter should hold requests, got %v", d)
+	}
+	if rl.State().PausedUntil == nil {
+		t.Errorf("State should report the pause")
+	}
+}
+
+func TestRetryWaitsOutA429(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	attempts, _, err := RetryPolicy{MaxAttempts: 2}.Do(t.Context(), func() error {
+		req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL, nil)
+		resp, err := doProviderRequest(t.Context(), "test-429", directClient, req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return &HTTPStatusError{Provider: "test-429", StatusCode: resp.StatusCode}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("Do = %d attempts, %v", attempts, err)
+	}
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("Retry did not wait for the Retry-After pause, took %s", elapsed)
+	}
+}
+
+func TestProxied429DoesNotPauseTheLimiter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	// Any client other than directClient stands for a proxied one
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, srv.URL, nil)
+	resp, err := doProviderRequest(t.Context(), "test-429-proxied", srv.Client(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if st := limiterFor("test-429-proxied").State(); st.PausedUntil != nil {
+		t.Errorf("A proxied 429 paused the shared limiter: %+v", st)
+	}
+}
@@ -0,0 +1,119 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"ip-proxy-checker/internal/storage"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy retries retryable errors with exponential backoff and jitter.
+type RetryPolicy struct {
+	MaxAttempts int // total attempts, including the first one
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// NewRetryPolicy builds the policy from the worker settings; retry_attempts
+// counts the retries after the first attempt.
+func NewRetryPolicy(cfg *storage.Config) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: cfg.Worker.RetryAttempts + 1,
+		BaseDelay:   cfg.Worker.RetryDelay,
+		MaxDelay:    cfg.Worker.RetryMaxDelay,
+	}
+}
+
+// Do runs fn until it succeeds, fails with a non-retryable error, runs out of
+// attempts or ctx is done. It returns the number of attempts made and the
+// error of each one.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) (int, []error, error) {
+	maxAttempts := p.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var errs []error
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		errs = append(errs, err)
+		if err == nil || attempt >= maxAttempts || !IsRetryable(err) || ctx.Err() != nil {
+			return attempt, errs, err
+		}
+
+		timer := time.NewTimer(p.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, errs, err
+		}
+	}
+}
+
+// backoff returns the delay after the given attempt: base * 2^(attempt-1),
+// capped at MaxDelay, with jitter in the upper half of the interval.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+	d := p.BaseDelay << (attempt - 1)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int64N(int64(half)+1))
+}
+
+// IsRetryable reports whether err is transient: timeouts, connection resets,
+// unexpected EOFs, 5xx and 429 responses. A retried 429 first waits out the
+// pause the provider limiter took from it. Auth failures and other 4xx never
+// are retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"407", "proxy authentication", "authentication failed", "username/password", "unauthorized", "forbidden"} {
+		if strings.Contains(msg, s) {
+			return false
+		}
+	}
+
+	// Cancellation is ours, not a transient upstream problem. Deadlines are
+	// retryable: Do stops on its own once the caller's context has expired.
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	for _, s := range []string{"timeout", "connection reset", "broken pipe", "eof", "server misbehaving", "temporarily unavailable"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&HTTPStatusError{Provider: "ipwho", StatusCode: 502}, true},
+		{&HTTPStatusError{Provider: "ipwho", StatusCode: 403}, false},
+		{&HTTPStatusError{Provider: "ipwho", StatusCode: 429}, true},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{io.ErrUnexpectedEOF, true},
+		{errors.New("dial tcp 1.2.3.4:8080: i/o timeout"), true},
+		{errors.New("proxyconnect tcp: Proxy Authentication Required"), false},
+		{errors.New("socks connect tcp 1.2.3.4:1080->x:80: username/password authentication failed"), false},
+		{errors.New("dial tcp 1.2.3.4:8080: connect: connection refused"), false},
+	}
+	for _, c := range cases {
+		if got := IsRetryable(c.err); got != c.want {
+			t.Errorf("IsRetryable(%v) = %v, want %v", c.err, got, c.want)
+		}
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	calls := 0
+	attempts, errs, err := policy.Do(context.Background(), func() error {
+		calls++
+		if calls < 2 {
+			return &HTTPStatusError{Provider: "ipwho", StatusCode: 503}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 || len(errs) != 2 {
+		t.Errorf("Expected success on attempt 2, got attempts=%d err=%v", attempts, err)
+	}
+
+	attempts, _, err = policy.Do(context.Background(), func() error {
+		return &HTTPStatusError{Provider: "ipwho", StatusCode: 401}
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("Non-retryable error must not be retried, got attempts=%d", attempts)
+	}
+
+	attempts, _, _ = policy.Do(context.Background(), func() error { return io.EOF })
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
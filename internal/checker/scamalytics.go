@@ -1,6 +1,7 @@
 package checker
 
 import (
+	"context"
 	"fmt"
 	"ip-proxy-checker/internal/models"
 	"ip-proxy-checker/internal/proxy"
@@ -10,17 +11,17 @@ import (
 	"github.com/PuerkitoBio/goquery"
 )
 
-func CheckScamalytics(ip string, proxyClient *proxy.ProxyClient) (*models.IPQualityResult, error) {
+func CheckScamalytics(ctx context.Context, ip string, proxyClient *proxy.ProxyClient) (*models.IPQualityResult, error) {
 	url := fmt.Sprintf("https://scamalytics.com/ip/%s", ip)
 
-	httpClient := http.DefaultClient
+	httpClient := directClient
 	userAgent := "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/121.0.0.0 Safari/537.36"
 	if proxyClient != nil {
 		httpClient = proxyClient.HTTPClient
 		userAgent = proxyClient.UserAgent
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -32,14 +33,14 @@ func CheckScamalytics(ip string, proxyClient *proxy.ProxyClient) (*models.IPQual
 	req.Header.Set("Connection", "keep-alive")
 	req.Header.Set("Referer", "https://scamalytics.com/")
 
-	resp, err := httpClient.Do(req)
+	resp, err := doProviderRequest(ctx, ProviderScamalytics, httpClient, req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("scamalytics bad status code: %d", resp.StatusCode)
+		return nil, &HTTPStatusError{Provider: ProviderScamalytics, StatusCode: resp.StatusCode}
 	}
 
 	doc, err := goquery.NewDocumentFromReader(resp.Body)
@@ -92,10 +93,7 @@ func CheckScamalytics(ip string, proxyClient *proxy.ProxyClient) (*models.IPQual
 	result.Proxy = strings.Contains(bodyText, "proxy: yes") || strings.Contains(bodyText, "is a proxy")
 	result.VPN = strings.Contains(bodyText, "vpn: yes") || strings.Contains(bodyText, "is a vpn")
 
-	// If score found, add a note that this is from Scamalytics
-	if result.FraudScore != "" {
-		result.Error = "(Source: Scamalytics)"
-	}
+	result.Source = ProviderScamalytics
 
 	return result, nil
 }
@@ -0,0 +1,215 @@
+package checker
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	PriorityInteractive = "interactive"
+	PriorityScheduled   = "scheduled"
+)
+
+// Scheduler enforces a process-wide concurrency limit shared by every check
+// job. Waiting work is admitted by start-time fair queuing: each (tenant,
+// priority) flow gets a share of the slots proportional to its weight, so a
+// large job cannot starve others and interactive work outpaces scheduled work.
+type Scheduler struct {
+	mu            sync.Mutex
+	capacity      int
+	ctrl          *AIMD // optional, lowers the capacity while it backs off
+	inUse         int
+	virtualTime   float64
+	flows         map[string]*flow
+	classWeights  map[string]float64
+	tenantWeights map[string]float64
+}
+
+type flow struct {
+	tenant     string
+	class      string
+	weight     float64
+	lastFinish float64
+	running    int
+	queue      []*waiter
+}
+
+type waiter struct {
+	ready   chan struct{}
+	start   float64
+	granted bool
+}
+
+type FlowStatus struct {
+	Tenant   string  `json:"tenant"`
+	Priority string  `json:"priority"`
+	Weight   float64 `json:"weight"`
+	Running  int     `json:"running"`
+	Queued   int     `json:"queued"`
+}
+
+type SchedulerStatus struct {
+	Capacity int          `json:"capacity"`
+	Limit    int          `json:"limit"` // slots currently handed out at most
+	InUse    int          `json:"in_use"`
+	Flows    []FlowStatus `json:"flows"`
+}
+
+// NewScheduler creates a scheduler with the given number of slots. Missing
+// weights default to 1.
+func NewScheduler(capacity int, classWeights, tenantWeights map[string]float64) *Scheduler {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Scheduler{
+		capacity:      capacity,
+		flows:         make(map[string]*flow),
+		classWeights:  classWeights,
+		tenantWeights: tenantWeights,
+	}
+}
+
+// Adapt shares ctrl's limit across every job: no more than ctrl.Limit()
+// slots are handed out at once.
+func (s *Scheduler) Adapt(ctrl *AIMD) *Scheduler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ctrl = ctrl
+	return s
+}
+
+// limit is the number of slots that may be in use. Called with s.mu held.
+func (s *Scheduler) limit() int {
+	if s.ctrl == nil {
+		return s.capacity
+	}
+	return min(s.capacity, s.ctrl.Limit())
+}
+
+func weightOf(weights map[string]float64, key string) float64 {
+	if w, ok := weights[key]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+func (s *Scheduler) flowFor(tenant, class string) *flow {
+	key := tenant + "\x00" + class
+	f, ok := s.flows[key]
+	if !ok {
+		f = &flow{
+			tenant: tenant,
+			class:  class,
+			weight: weightOf(s.tenantWeights, tenant) * weightOf(s.classWeights, class),
+		}
+		s.flows[key] = f
+	}
+	return f
+}
+
+// Acquire blocks until a slot is granted to the flow or ctx is done. The
+// returned release function must be called exactly once when the work ends.
+func (s *Scheduler) Acquire(ctx context.Context, tenant, class string) (func(), error) {
+	if class == "" {
+		class = PriorityInteractive
+	}
+
+	s.mu.Lock()
+	f := s.flowFor(tenant, class)
+	w := &waiter{ready: make(chan struct{}), start: math.Max(s.virtualTime, f.lastFinish)}
+	f.lastFinish = w.start + 1/f.weight
+	f.queue = append(f.queue, w)
+	s.dispatch()
+	s.mu.Unlock()
+
+	release := func() {
+		s.mu.Lock()
+		s.inUse--
+		f.running--
+		s.dispatch()
+		s.cleanup(f)
+		s.mu.Unlock()
+	}
+
+	select {
+	case <-w.ready:
+		return release, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if w.granted {
+			// Granted while we were giving up: hand the slot back
+			s.inUse--
+			f.running--
+			s.dispatch()
+		} else {
+			for i, q := range f.queue {
+				if q == w {
+					f.queue = append(f.queue[:i], f.queue[i+1:]...)
+					break
+				}
+			}
+		}
+		s.cleanup(f)
+		return nil, ctx.Err()
+	}
+}
+
+// dispatch grants free slots to the queued waiters with the smallest start tags.
+func (s *Scheduler) dispatch() {
+	for s.inUse < s.limit() {
+		var next *flow
+		for _, f := range s.flows {
+			if len(f.queue) == 0 {
+				continue
+			}
+			if next == nil || f.queue[0].start < next.queue[0].start {
+				next = f
+			}
+		}
+		if next == nil {
+			return
+		}
+
+		w := next.queue[0]
+		next.queue = next.queue[1:]
+		next.running++
+		s.inUse++
+		s.virtualTime = w.start
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+// cleanup forgets idle flows so the map does not grow with every tenant ever
+// seen. A flow that comes back starts again at the current virtual time.
+func (s *Scheduler) cleanup(f *flow) {
+	if f.running == 0 && len(f.queue) == 0 {
+		delete(s.flows, f.tenant+"\x00"+f.class)
+	}
+}
+
+func (s *Scheduler) Status() SchedulerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := SchedulerStatus{Capacity: s.capacity, Limit: s.limit(), InUse: s.inUse, Flows: []FlowStatus{}}
+	for _, f := range s.flows {
+		status.Flows = append(status.Flows, FlowStatus{
+			Tenant:   f.tenant,
+			Priority: f.class,
+			Weight:   f.weight,
+			Running:  f.running,
+			Queued:   len(f.queue),
+		})
+	}
+	sort.Slice(status.Flows, func(i, j int) bool {
+		if status.Flows[i].Tenant != status.Flows[j].Tenant {
+			return status.Flows[i].Tenant < status.Flows[j].Tenant
+		}
+		return status.Flows[i].Priority < status.Flows[j].Priority
+	})
+	return status
+}
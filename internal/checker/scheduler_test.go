@@ -0,0 +1,87 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerWeightedFairOrder(t *testing.T) {
+	s := NewScheduler(1, map[string]float64{PriorityInteractive: 3, PriorityScheduled: 1}, nil)
+	hold, err := s.Acquire(context.Background(), "holder", PriorityInteractive)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	enqueue := func(name, tenant, class string, queued int) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := s.Acquire(context.Background(), tenant, class)
+			if err != nil {
+				t.Errorf("Acquire %s failed: %v", name, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			release()
+		}()
+		// Wait until the waiter is queued so start tags are assigned in order
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			total := 0
+			for _, f := range s.Status().Flows {
+				total += f.Queued
+			}
+			if total == queued {
+				return
+			}
+		}
+		t.Fatalf("%s was never queued", name)
+	}
+
+	for i := 1; i <= 3; i++ {
+		enqueue(fmt.Sprintf("batch%d", i), "batch", PriorityScheduled, i)
+	}
+	for i := 1; i <= 3; i++ {
+		enqueue(fmt.Sprintf("ui%d", i), "ui", PriorityInteractive, 3+i)
+	}
+
+	hold()
+	wg.Wait()
+
+	if len(order) != 6 {
+		t.Fatalf("Expected 6 grants, got %v", order)
+	}
+	// Interactive work gets three slots for every scheduled one, so it
+	// overtakes the batch that queued first
+	if order[4] != "batch2" || order[5] != "batch3" {
+		t.Errorf("Unexpected grant order %v", order)
+	}
+	if status := s.Status(); status.InUse != 0 || len(status.Flows) != 0 {
+		t.Errorf("Expected idle scheduler, got %+v", status)
+	}
+}
+
+func TestSchedulerCancelledWaiter(t *testing.T) {
+	s := NewScheduler(1, nil, nil)
+	hold, _ := s.Acquire(context.Background(), "a", PriorityInteractive)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := s.Acquire(ctx, "b", PriorityInteractive); err == nil {
+		t.Fatalf("Expected Acquire to fail once ctx expires")
+	}
+
+	hold()
+	release, err := s.Acquire(context.Background(), "c", PriorityInteractive)
+	if err != nil {
+		t.Fatalf("Slot was not returned: %v", err)
+	}
+	release()
+}
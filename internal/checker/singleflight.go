@@ -0,0 +1,74 @@
+package checker
+
+import (
+	"context"
+	"sync"
+)
+
+type flightCall struct {
+	done    chan struct{}
+	val     interface{}
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup coalesces concurrent calls with the same key into a single execution.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// Do runs fn once per key at a time. Callers arriving while fn is running wait
+// for it and receive the same result with shared set to true.
+//
+// fn runs with a context detached from any single caller, so one caller
+// giving up does not fail the others; it is cancelled once every caller has
+// gone away. It keeps the deadline of the caller that started it.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (val interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	c, shared := g.calls[key]
+	if shared {
+		c.waiters++
+	} else {
+		var callCtx context.Context
+		var cancel context.CancelFunc
+		if deadline, ok := ctx.Deadline(); ok {
+			callCtx, cancel = context.WithDeadline(context.WithoutCancel(ctx), deadline)
+		} else {
+			callCtx, cancel = context.WithCancel(context.WithoutCancel(ctx))
+		}
+		c = &flightCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.calls[key] = c
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					c.err = &panicError{value: r}
+				}
+				g.mu.Lock()
+				delete(g.calls, key)
+				g.mu.Unlock()
+				cancel()
+				close(c.done)
+			}()
+			c.val, c.err = fn(callCtx)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.val, c.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			c.cancel()
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err(), shared
+	}
+}
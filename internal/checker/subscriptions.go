@@ -0,0 +1,318 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"ip-proxy-checker/internal/models"
+	"ip-proxy-checker/internal/parser"
+	"ip-proxy-checker/internal/storage"
+	"maps"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSubscriptionInterval = time.Hour
+	MinSubscriptionInterval     = time.Minute
+)
+
+// SubscriptionHandler starts checks over the entries a fetch added and
+// returns the IDs of their jobs without waiting for them.
+type SubscriptionHandler func(ctx context.Context, name string, ips []string, proxies []models.ProxyInput) []string
+
+// SubscriptionStatus is the state of one subscription as of its last fetch.
+type SubscriptionStatus struct {
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	Format      string    `json:"format,omitempty"`
+	Interval    string    `json:"interval"`
+	HeaderNames []string  `json:"header_names,omitempty"` // header values are never shown
+	Origin      string    `json:"origin"`                 // config or api
+	LastFetch   time.Time `json:"last_fetch"`
+	NextFetch   time.Time `json:"next_fetch"`
+	NotModified bool      `json:"not_modified,omitempty"` // the server answered 304
+	Entries     int       `json:"entries"`                // accepted entries of the last fetch
+	Added       int       `json:"added"`                  // not in the fetch before
+	Removed     int       `json:"removed"`
+	Rejected    int       `json:"rejected"`
+	Jobs        []string  `json:"jobs,omitempty"` // check jobs started for the added entries
+	Error       string    `json:"error,omitempty"`
+}
+
+type subscription struct {
+	cfg    storage.SubscriptionConfig
+	cancel context.CancelFunc
+
+	fetching sync.Mutex // one fetch at a time, scheduled or manual
+
+	mu           sync.Mutex
+	seen         map[string]bool // normalized entries of the last successful fetch
+	etag         string
+	lastModified string
+	status       SubscriptionStatus
+}
+
+// Subscriptions fetches remote lists on their own interval and hands the
+// entries that were not in the previous fetch to a handler.
+type Subscriptions struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	client  *http.Client
+	maxSize int64
+	handler SubscriptionHandler
+	subs    map[string]*subscription
+}
+
+// NewSubscriptions creates an empty set; subscriptions run until ctx is done.
+func NewSubscriptions(ctx context.Context, cfg *storage.Config, handler SubscriptionHandler) *Subscriptions {
+	return &Subscriptions{
+		ctx:     ctx,
+		client:  &http.Client{Timeout: cfg.Subscriptions.Timeout},
+		maxSize: int64(cfg.Parser.MaxUploadMB) << 20,
+		handler: handler,
+		subs:    make(map[string]*subscription),
+	}
+}
+
+// Add validates a subscription and starts fetching it, right away and then
+// every interval. origin records where it was defined.
+func (s *Subscriptions) Add(cfg storage.SubscriptionConfig, origin string) (SubscriptionStatus, error) {
+	if cfg.Name == "" {
+		return SubscriptionStatus{}, errors.New("subscription name is required")
+	}
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return SubscriptionStatus{}, fmt.Errorf("invalid subscription URL %q", cfg.URL)
+	}
+	switch cfg.Format {
+	case "plain":
+		cfg.Format = parser.FormatText
+	case "", parser.FormatText, parser.FormatCSV, "tsv", parser.FormatJSON, parser.FormatClash, parser.FormatSingBox, parser.FormatV2Ray:
+	default:
+		return SubscriptionStatus{}, fmt.Errorf("invalid format %q: use plain, csv, json, clash, sing-box or v2ray", cfg.Format)
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = DefaultSubscriptionInterval
+	}
+	if cfg.Interval < MinSubscriptionInterval {
+		return SubscriptionStatus{}, fmt.Errorf("interval must be at least %s", MinSubscriptionInterval)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subs[cfg.Name]; ok {
+		return SubscriptionStatus{}, fmt.Errorf("subscription %q already exists", cfg.Name)
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	sub := &subscription{
+		cfg:    cfg,
+		cancel: cancel,
+		status: SubscriptionStatus{
+			Name:        cfg.Name,
+			URL:         cfg.URL,
+			Format:      cfg.Format,
+			Interval:    cfg.Interval.String(),
+			HeaderNames: slices.Sorted(maps.Keys(cfg.Headers)),
+			Origin:      origin,
+			NextFetch:   time.Now(),
+		},
+	}
+	s.subs[cfg.Name] = sub
+	status := sub.status
+	go s.run(ctx, sub)
+	return status, nil
+}
+
+// Remove stops a subscription. Checks it already started keep running.
+func (s *Subscriptions) Remove(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[name]
+	if ok {
+		sub.cancel()
+		delete(s.subs, name)
+	}
+	return ok
+}
+
+// Refresh fetches a subscription now, outside its schedule.
+func (s *Subscriptions) Refresh(ctx context.Context, name string) (SubscriptionStatus, bool) {
+	s.mu.Lock()
+	sub, ok := s.subs[name]
+	s.mu.Unlock()
+	if !ok {
+		return SubscriptionStatus{}, false
+	}
+	s.fetch(ctx, sub)
+	return sub.snapshot(), true
+}
+
+// Status lists every subscription by name.
+func (s *Subscriptions) Status() []SubscriptionStatus {
+	s.mu.Lock()
+	list := make([]SubscriptionStatus, 0, len(s.subs))
+	for _, sub := range s.subs {
+		list = append(list, sub.snapshot())
+	}
+	s.mu.Unlock()
+	sort.Slice(list, func(i, k int) bool { return list[i].Name < list[k].Name })
+	return list
+}
+
+func (sub *subscription) snapshot() SubscriptionStatus {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.status
+}
+
+func (s *Subscriptions) run(ctx context.Context, sub *subscription) {
+	ticker := time.NewTicker(sub.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		s.fetch(ctx, sub)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fetch downloads the list, diffs it against the previous fetch and starts
+// checks for the entries that are new. A failed fetch keeps the previous
+// entries, so nothing is checked twice once the source recovers.
+func (s *Subscriptions) fetch(ctx context.Context, sub *subscription) {
+	sub.fetching.Lock()
+	defer sub.fetching.Unlock()
+
+	report, etag, lastModified, err := s.download(ctx, sub)
+	now := time.Now()
+
+	sub.mu.Lock()
+	sub.status.LastFetch = now
+	sub.status.NextFetch = now.Add(sub.cfg.Interval)
+	sub.status.NotModified = err == nil && report == nil
+	sub.status.Added, sub.status.Removed, sub.status.Jobs = 0, 0, nil
+	if err != nil {
+		sub.status.Error = err.Error()
+		sub.mu.Unlock()
+		return
+	}
+	sub.status.Error = ""
+	if report == nil {
+		sub.mu.Unlock()
+		return
+	}
+
+	current := make(map[string]bool, report.Total)
+	var ips []string
+	var proxies []models.ProxyInput
+	for _, ip := range report.IPs {
+		current[ip.IP] = true
+		if !sub.seen[ip.IP] {
+			ips = append(ips, ip.IP)
+		}
+	}
+	for _, p := range report.Proxies {
+		n := p.Normalized()
+		current[n] = true
+		if !sub.seen[n] {
+			proxies = append(proxies, p)
+		}
+	}
+	for n := range sub.seen {
+		if !current[n] {
+			sub.status.Removed++
+		}
+	}
+	sub.seen, sub.etag, sub.lastModified = current, etag, lastModified
+	sub.status.Entries = report.Total
+	sub.status.Added = len(ips) + len(proxies)
+	sub.status.Rejected = len(report.Rejected)
+	sub.mu.Unlock()
+
+	if len(ips)+len(proxies) > 0 {
+		jobs := s.handler(s.ctx, sub.cfg.Name, ips, proxies)
+		sub.mu.Lock()
+		sub.status.Jobs = jobs
+		sub.mu.Unlock()
+	}
+}
+
+// download fetches and parses the list. A nil report means the server
+// reported it unchanged since the last fetch.
+func (s *Subscriptions) download(ctx context.Context, sub *subscription) (*parser.ParseReport, string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sub.cfg.URL, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	for k, v := range sub.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	sub.mu.Lock()
+	if sub.etag != "" {
+		req.Header.Set("If-None-Match", sub.etag)
+	}
+	if sub.lastModified != "" {
+		req.Header.Set("If-Modified-Since", sub.lastModified)
+	}
+	sub.mu.Unlock()
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, "", "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	format := sub.cfg.Format
+	if format == "" {
+		switch ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct {
+		case "application/json":
+			format = parser.FormatJSON
+		case "text/csv":
+			format = parser.FormatCSV
+		}
+	}
+	report, err := parser.ParseFile(&sizeLimitReader{r: resp.Body, n: s.maxSize}, parser.FileOptions{
+		ParseOptions: parser.ParseOptions{Dedupe: true},
+		Name:         path.Base(req.URL.Path),
+		Format:       format,
+		Delimiter:    sub.cfg.Delimiter,
+		Header:       sub.cfg.Header,
+		Columns:      parser.Columns(sub.cfg.Columns),
+	})
+	if err != nil {
+		return nil, "", "", err
+	}
+	return &report, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+// sizeLimitReader fails once more than n bytes have been read, instead of
+// silently truncating like io.LimitReader.
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, errors.New("list exceeds parser.max_upload_mb")
+	}
+	return n, err
+}
@@ -0,0 +1,92 @@
+package checker
+
+import (
+	"context"
+	"fmt"
+	"ip-proxy-checker/internal/models"
+	"ip-proxy-checker/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionDiffsFetches(t *testing.T) {
+	var mu sync.Mutex
+	body := "ip;port;login;password\n1.2.3.4;8080;u;p\n5.6.7.8;3128;;\n"
+	etag := `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		fmt.Fprint(w, body)
+	}))
+	defer srv.Close()
+
+	added := make(chan []string, 4)
+	cfg, _ := storage.LoadConfig("")
+	subs := NewSubscriptions(context.Background(), cfg, func(ctx context.Context, name string, ips []string, proxies []models.ProxyInput) []string {
+		var list []string
+		for _, p := range proxies {
+			list = append(list, p.Normalized())
+		}
+		added <- list
+		return []string{"quality-1"}
+	})
+	_, err := subs.Add(storage.SubscriptionConfig{
+		Name:     "supplier",
+		URL:      srv.URL + "/list.csv",
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+		Interval: time.Hour,
+	}, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer subs.Remove("supplier")
+
+	select {
+	case got := <-added:
+		if want := []string{"u:p@1.2.3.4:8080", "5.6.7.8:3128"}; !slices.Equal(got, want) {
+			t.Fatalf("First fetch added %v, want %v", got, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("First fetch did not run")
+	}
+
+	status, _ := subs.Refresh(context.Background(), "supplier")
+	if !status.NotModified || status.Added != 0 {
+		t.Errorf("Expected an unchanged fetch, got %+v", status)
+	}
+
+	mu.Lock()
+	body = "ip;port;login;password\n5.6.7.8;3128;;\n9.9.9.9;80;;\n"
+	etag = `"v2"`
+	mu.Unlock()
+	status, _ = subs.Refresh(context.Background(), "supplier")
+	if got := <-added; !slices.Equal(got, []string{"9.9.9.9:80"}) {
+		t.Errorf("Second fetch added %v", got)
+	}
+	if status.Entries != 2 || status.Added != 1 || status.Removed != 1 || !slices.Equal(status.Jobs, []string{"quality-1"}) {
+		t.Errorf("Unexpected status %+v", status)
+	}
+	if !slices.Equal(status.HeaderNames, []string{"Authorization"}) {
+		t.Errorf("HeaderNames = %v", status.HeaderNames)
+	}
+
+	if _, err := subs.Add(storage.SubscriptionConfig{Name: "supplier", URL: srv.URL}, "api"); err == nil {
+		t.Error("Expected an error for a duplicate name")
+	}
+	if _, err := subs.Add(storage.SubscriptionConfig{Name: "x", URL: "ftp://example.com"}, "api"); err == nil {
+		t.Error("Expected an error for a non-HTTP URL")
+	}
+}
@@ -2,67 +2,200 @@ package checker
 
 import (
 	"context"
+	"iter"
 	"sync"
+	"time"
 )
 
-type Job struct {
-	ID   int
-	Type string // "whois" or "ipquality"
-	Data interface{}
+// Result is the output of one job together with the position of its input.
+// Err is set when the job panicked or was not admitted; Value is then the zero value.
+type Result[Out any] struct {
+	Index int
+	Value Out
+	Err   error
 }
 
-type WorkerPool struct {
-	poolSize int
-	jobs     chan Job
-	results  chan interface{}
-	wg       sync.WaitGroup
-	ctx      context.Context
-	cancel   context.CancelFunc
+type job[In, Out any] struct {
+	index int
+	data  In
+	slot  chan Result[Out] // ordered mode only
 }
 
-func NewWorkerPool(size int) *WorkerPool {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &WorkerPool{
-		poolSize: size,
-		jobs:     make(chan Job, 1000),
-		results:  make(chan interface{}, 1000),
-		ctx:      ctx,
-		cancel:   cancel,
+// WorkerPool runs fn over a stream of inputs with a fixed number of workers.
+// Inputs are fed while results are drained, so batches of any size run in
+// constant memory.
+type WorkerPool[In, Out any] struct {
+	size    int
+	fn      func(context.Context, In) Out
+	acquire func(context.Context) (func(), error)
+	aimd    *AIMD
+	outcome func(Out) error
+}
+
+func NewWorkerPool[In, Out any](size int, fn func(context.Context, In) Out) *WorkerPool[In, Out] {
+	if size < 1 {
+		size = 1
 	}
+	return &WorkerPool[In, Out]{size: size, fn: fn}
 }
 
-func (wp *WorkerPool) Start(workerFunc func(Job) interface{}) {
-	for i := 0; i < wp.poolSize; i++ {
-		wp.wg.Add(1)
+// Adapt reports every finished job to ctrl, which sizes the Scheduler gating
+// the pool. outcome turns a job's output into the error, if any, that ctrl
+// learns from. The pool should be sized to ctrl.Max().
+func (wp *WorkerPool[In, Out]) Adapt(ctrl *AIMD, outcome func(Out) error) *WorkerPool[In, Out] {
+	wp.aimd = ctrl
+	wp.outcome = outcome
+	return wp
+}
+
+// Gate makes every job take a slot from acquire before it runs, e.g. from the
+// process-wide Scheduler. A job that cannot get one fails with that error.
+func (wp *WorkerPool[In, Out]) Gate(acquire func(context.Context) (func(), error)) *WorkerPool[In, Out] {
+	wp.acquire = acquire
+	return wp
+}
+
+// Stream returns results in completion order. The channel is closed once every
+// input has been processed or ctx is done; callers must drain it or cancel ctx.
+func (wp *WorkerPool[In, Out]) Stream(ctx context.Context, inputs iter.Seq[In]) <-chan Result[Out] {
+	out := make(chan Result[Out], wp.size)
+	jobs := make(chan job[In, Out])
+
+	go wp.feed(ctx, inputs, jobs, nil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < wp.size; i++ {
+		wg.Add(1)
 		go func() {
-			defer wp.wg.Done()
-			for {
+			defer wg.Done()
+			for j := range jobs {
 				select {
-				case <-wp.ctx.Done():
+				case out <- wp.run(ctx, j):
+				case <-ctx.Done():
 					return
-				case job, ok := <-wp.jobs:
-					if !ok {
-						return
-					}
-					result := workerFunc(job)
-					wp.results <- result
 				}
 			}
 		}()
 	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+// StreamOrdered returns results in input order. At most a few times the pool
+// size of finished results are buffered while waiting for a slow job.
+func (wp *WorkerPool[In, Out]) StreamOrdered(ctx context.Context, inputs iter.Seq[In]) <-chan Result[Out] {
+	out := make(chan Result[Out], wp.size)
+	jobs := make(chan job[In, Out])
+	order := make(chan chan Result[Out], wp.size*4)
+
+	go wp.feed(ctx, inputs, jobs, order)
+
+	for i := 0; i < wp.size; i++ {
+		go func() {
+			for j := range jobs {
+				j.slot <- wp.run(ctx, j)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(out)
+		for slot := range order {
+			select {
+			case res := <-slot:
+				select {
+				case out <- res:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Run processes every input and returns the outputs in input order, or the
+// outputs completed so far and ctx.Err() if ctx is done first.
+func (wp *WorkerPool[In, Out]) Run(ctx context.Context, inputs iter.Seq[In]) ([]Result[Out], error) {
+	var results []Result[Out]
+	for res := range wp.StreamOrdered(ctx, inputs) {
+		results = append(results, res)
+	}
+	return results, ctx.Err()
 }
 
-func (wp *WorkerPool) AddJob(job Job) {
-	wp.jobs <- job
+// feed hands inputs to the workers until they run out or ctx is done. In
+// ordered mode each job's result slot is queued in input order first.
+func (wp *WorkerPool[In, Out]) feed(ctx context.Context, inputs iter.Seq[In], jobs chan<- job[In, Out], order chan<- chan Result[Out]) {
+	defer close(jobs)
+	if order != nil {
+		defer close(order)
+	}
+
+	index := 0
+	for data := range inputs {
+		j := job[In, Out]{index: index, data: data}
+		index++
+		if order != nil {
+			j.slot = make(chan Result[Out], 1)
+			select {
+			case order <- j.slot:
+			case <-ctx.Done():
+				return
+			}
+		}
+		select {
+		case jobs <- j:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run executes one job once the pool's gate admits it. The outcome is
+// reported before the slot is released, so the gate sees the new limit.
+func (wp *WorkerPool[In, Out]) run(ctx context.Context, j job[In, Out]) Result[Out] {
+	res := Result[Out]{Index: j.index}
+	if wp.acquire != nil {
+		release, err := wp.acquire(ctx)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		defer release()
+	}
+
+	start := time.Now()
+	res.Value, res.Err = wp.call(ctx, j.data)
+	if wp.aimd != nil {
+		err := res.Err
+		if err == nil && wp.outcome != nil {
+			err = wp.outcome(res.Value)
+		}
+		wp.aimd.Observe(time.Since(start), err)
+	}
+	return res
 }
 
-func (wp *WorkerPool) Stop() {
-	wp.cancel()
-	close(wp.jobs)
-	wp.wg.Wait()
-	close(wp.results)
+// call runs fn, turning a panic into an error so the pool survives.
+func (wp *WorkerPool[In, Out]) call(ctx context.Context, data In) (out Out, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &panicError{value: r}
+		}
+	}()
+	return wp.fn(ctx, data), nil
 }
 
-func (wp *WorkerPool) Results() <-chan interface{} {
-	return wp.results
+// WithBudget bounds ctx by d. A budget <= 0 leaves ctx unbounded.
+func WithBudget(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
 }
@@ -0,0 +1,83 @@
+package checker
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolOrderedLargeBatch(t *testing.T) {
+	inputs := make([]int, 5000)
+	for i := range inputs {
+		inputs[i] = i
+	}
+	pool := NewWorkerPool(4, func(ctx context.Context, n int) int {
+		if n%7 == 0 {
+			time.Sleep(time.Microsecond)
+		}
+		if n == 42 {
+			panic("boom")
+		}
+		return n * 2
+	})
+
+	results, err := pool.Run(context.Background(), slices.Values(inputs))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(results) != len(inputs) {
+		t.Fatalf("Expected %d results, got %d", len(inputs), len(results))
+	}
+	for i, res := range results {
+		if res.Index != i {
+			t.Fatalf("Result %d has index %d", i, res.Index)
+		}
+		if i == 42 {
+			if res.Err == nil {
+				t.Errorf("Expected panic to be reported as an error")
+			}
+			continue
+		}
+		if res.Value != i*2 {
+			t.Errorf("Result %d = %d, want %d", i, res.Value, i*2)
+		}
+	}
+}
+
+func TestWorkerPoolUnorderedKeepsIndex(t *testing.T) {
+	inputs := []string{"a", "bb", "ccc", "dddd"}
+	pool := NewWorkerPool(2, func(ctx context.Context, s string) int { return len(s) })
+
+	seen := 0
+	for res := range pool.Stream(context.Background(), slices.Values(inputs)) {
+		if res.Value != len(inputs[res.Index]) {
+			t.Errorf("Result for index %d does not match its input", res.Index)
+		}
+		seen++
+	}
+	if seen != len(inputs) {
+		t.Errorf("Expected %d results, got %d", len(inputs), seen)
+	}
+}
+
+func TestWorkerPoolCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := NewWorkerPool(2, func(ctx context.Context, n int) int {
+		<-ctx.Done()
+		return n
+	})
+
+	done := make(chan struct{})
+	go func() {
+		pool.Run(ctx, slices.Values(make([]int, 100)))
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Pool did not stop after cancellation")
+	}
+}
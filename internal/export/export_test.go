@@ -0,0 +1,128 @@
+package export
+
+import (
+	"encoding/json"
+	"ip-proxy-checker/internal/models"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func checked() []models.CheckedProxy {
+	return []models.CheckedProxy{
+		{
+			Proxy:  models.ProxyInput{Host: "1.2.3.4", Port: "8080", Username: "u", Password: "p"},
+			Result: models.IPQualityResult{Status: "Live", Protocol: "http", Country: "US", FraudScore: "10", ConnectionClass: models.ConnectionClass{ConnectionType: "residential"}},
+		},
+		{
+			Proxy:  models.ProxyInput{Host: "5.6.7.8", Port: "1080"},
+			Result: models.IPQualityResult{Status: "Live", Protocol: "socks5", Country: "DE", FraudScore: "85", ConnectionClass: models.ConnectionClass{ConnectionType: "datacenter"}},
+		},
+		{
+			Proxy:  models.ProxyInput{Scheme: "https", Host: "proxy.example.com", Port: "443", Label: "node", TLS: &models.ProxyTLS{ServerName: "sni.example.com"}},
+			Result: models.IPQualityResult{Status: "Live", Protocol: "https", Country: "United States", FraudScore: "N/A"},
+		},
+		{
+			Proxy:  models.ProxyInput{Host: "9.9.9.9", Port: "3128"},
+			Result: models.IPQualityResult{Status: "Dead"},
+		},
+	}
+}
+
+func TestFilter(t *testing.T) {
+	score := 50
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{"none", Filter{}, 4},
+		{"live", Filter{LiveOnly: true}, 3},
+		{"score", Filter{MaxFraudScore: &score}, 1},
+		{"country", Filter{Countries: []string{"us"}}, 2},
+		{"country name", Filter{Countries: []string{"germany", "Atlantis"}}, 1},
+		{"type", Filter{LiveOnly: true, ConnectionTypes: []string{"datacenter", "mobile"}}, 1},
+	}
+	for _, tt := range tests {
+		if got := len(tt.filter.Apply(checked())); got != tt.want {
+			t.Errorf("%s: got %d entries, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRenderLists(t *testing.T) {
+	list := Filter{LiveOnly: true}.Apply(checked())
+	var b strings.Builder
+	if err := Render(&b, FormatPlain, list, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "1.2.3.4:8080:u:p\n5.6.7.8:1080\nproxy.example.com:443\n"; b.String() != want {
+		t.Errorf("plain = %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	if err := Render(&b, FormatURL, list, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://u:p@1.2.3.4:8080\nsocks5://5.6.7.8:1080\nhttps://proxy.example.com:443\n"; b.String() != want {
+		t.Errorf("url = %q, want %q", b.String(), want)
+	}
+
+	if err := Render(&b, "csv", list, Options{}); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestRenderConfigs(t *testing.T) {
+	list := Filter{LiveOnly: true}.Apply(checked())
+	var b strings.Builder
+	if err := Render(&b, FormatClash, list, Options{Group: "pool"}); err != nil {
+		t.Fatal(err)
+	}
+	var clash struct {
+		Proxies     []clashProxy `yaml:"proxies"`
+		ProxyGroups []clashGroup `yaml:"proxy-groups"`
+	}
+	if err := yaml.Unmarshal([]byte(b.String()), &clash); err != nil {
+		t.Fatalf("Clash output is not YAML: %v", err)
+	}
+	if len(clash.Proxies) != 3 || clash.Proxies[0].Name != "US 1.2.3.4:8080" || clash.Proxies[1].Type != "socks5" {
+		t.Errorf("Unexpected Clash proxies %+v", clash.Proxies)
+	}
+	if p := clash.Proxies[2]; p.Name != "node" || p.Type != "http" || !p.TLS || p.SNI != "sni.example.com" {
+		t.Errorf("Unexpected Clash TLS proxy %+v", p)
+	}
+	if len(clash.ProxyGroups) != 1 || clash.ProxyGroups[0].Name != "pool" || clash.ProxyGroups[0].Type != "load-balance" || len(clash.ProxyGroups[0].Proxies) != 3 {
+		t.Errorf("Unexpected Clash groups %+v", clash.ProxyGroups)
+	}
+
+	b.Reset()
+	if err := Render(&b, FormatSingBox, list, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	var box struct {
+		Outbounds []singBoxOutbound `json:"outbounds"`
+	}
+	if err := json.Unmarshal([]byte(b.String()), &box); err != nil {
+		t.Fatalf("sing-box output is not JSON: %v", err)
+	}
+	if len(box.Outbounds) != 3 || box.Outbounds[1].Type != "socks" || box.Outbounds[1].Version != "5" || box.Outbounds[0].Username != "u" {
+		t.Errorf("Unexpected sing-box outbounds %+v", box.Outbounds)
+	}
+	if o := box.Outbounds[2]; o.TLS == nil || !o.TLS.Enabled || o.TLS.ServerName != "sni.example.com" {
+		t.Errorf("Unexpected sing-box TLS outbound %+v", o)
+	}
+}
+
+func TestNodeNamesAreUnique(t *testing.T) {
+	list := []models.CheckedProxy{
+		{Proxy: models.ProxyInput{Host: "1.1.1.1", Port: "80", Label: "a"}},
+		{Proxy: models.ProxyInput{Host: "2.2.2.2", Port: "80", Label: "a"}},
+		{Proxy: models.ProxyInput{Host: "3.3.3.3", Port: "80"}},
+	}
+	got := nodeNames(list)
+	if got[0] != "a" || got[1] != "a #2" || got[2] != "3.3.3.3:80" {
+		t.Errorf("nodeNames = %v", got)
+	}
+}
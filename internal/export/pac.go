@@ -0,0 +1,194 @@
+package export
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"ip-proxy-checker/internal/models"
+	"slices"
+	"strings"
+)
+
+// DefaultPACFailover is the number of proxies a rule tries when it sets none.
+const DefaultPACFailover = 3
+
+// PACRule routes the hosts matching one of its domains through the live
+// proxies its selector picks, fastest first. Empty selector fields match any
+// proxy.
+type PACRule struct {
+	Domains        []string `yaml:"domains" json:"domains"` // example.com (and its subdomains) or a shell pattern like *.example.*
+	Country        string   `yaml:"country" json:"country,omitempty"`
+	Tag            string   `yaml:"tag" json:"tag,omitempty"` // a proxy tag or config node name
+	ConnectionType string   `yaml:"connection_type" json:"connection_type,omitempty"`
+	Failover       int      `yaml:"failover" json:"failover,omitempty"` // proxies tried in turn
+	Direct         bool     `yaml:"direct" json:"direct,omitempty"`     // go direct once they all failed
+}
+
+// PACOptions are the rules of a PAC file, tried in order.
+type PACOptions struct {
+	Rules   []PACRule
+	Default string // result for hosts no rule matches, DIRECT when empty
+}
+
+// Validate checks the domain patterns and selectors of every rule.
+func (o PACOptions) Validate() error {
+	for i, rule := range o.Rules {
+		if len(rule.Domains) == 0 {
+			return fmt.Errorf("rule %d has no domains", i+1)
+		}
+		for _, d := range rule.Domains {
+			if !validDomainPattern(d) {
+				return fmt.Errorf("rule %d: invalid domain pattern %q", i+1, d)
+			}
+		}
+		if strings.ContainsAny(rule.Country+rule.Tag+rule.ConnectionType, "\r\n") {
+			return fmt.Errorf("rule %d: selector contains a line break", i+1)
+		}
+		if _, _, ok := models.LookupCountry(rule.Country); rule.Country != "" && !ok {
+			return fmt.Errorf("rule %d: unknown country %q", i+1, rule.Country)
+		}
+		if rule.Failover < 0 {
+			return fmt.Errorf("rule %d: failover must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+func validDomainPattern(d string) bool {
+	if d == "" {
+		return false
+	}
+	for _, r := range d {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(".-_*?", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the live proxies the rule picks, in latency order. Proxies
+// without a measured latency come last.
+func (rule PACRule) Select(list []models.CheckedProxy) []models.CheckedProxy {
+	var out []models.CheckedProxy
+	for _, c := range RankByLatency(list) {
+		if rule.Country != "" && !c.Result.InCountry(rule.Country) {
+			continue
+		}
+		if rule.ConnectionType != "" && !strings.EqualFold(rule.ConnectionType, c.Result.ConnectionType) {
+			continue
+		}
+		if rule.Tag != "" && !HasTag(c.Proxy, rule.Tag) {
+			continue
+		}
+		out = append(out, c)
+		if len(out) == cmp.Or(rule.Failover, DefaultPACFailover) {
+			break
+		}
+	}
+	return out
+}
+
+// HasTag reports whether a proxy carries tag or is the config node named tag.
+func HasTag(p models.ProxyInput, tag string) bool {
+	return containsFold(p.Tags, tag) || (p.Label != "" && strings.EqualFold(p.Label, tag))
+}
+
+// RankByLatency returns the live proxies, fastest first.
+func RankByLatency(list []models.CheckedProxy) []models.CheckedProxy {
+	live := Filter{LiveOnly: true}.Apply(list)
+	slices.SortStableFunc(live, func(a, b models.CheckedProxy) int {
+		la, lb := a.Result.LatencyMS, b.Result.LatencyMS
+		switch {
+		case la == lb:
+			return 0
+		case la == 0:
+			return 1
+		case lb == 0:
+			return -1
+		}
+		return cmp.Compare(la, lb)
+	})
+	return live
+}
+
+// RenderPAC writes a proxy auto-config script. Browsers cannot take proxy
+// credentials from a PAC file, so they prompt for them.
+func RenderPAC(w io.Writer, list []models.CheckedProxy, opts PACOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Proxy auto-config generated by ip-proxy-checker from %d live proxies.\n", len(RankByLatency(list)))
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	b.WriteString("  host = host.toLowerCase();\n")
+	for _, rule := range opts.Rules {
+		b.WriteString("\n")
+		selected := rule.Select(list)
+		if len(selected) == 0 {
+			fmt.Fprintf(&b, "  // %s: no live proxy matches %s\n", strings.Join(rule.Domains, ", "), rule.selector())
+			continue
+		}
+		directives := make([]string, 0, len(selected)+1)
+		for _, c := range selected {
+			directives = append(directives, pacDirective(c))
+		}
+		if rule.Direct {
+			directives = append(directives, "DIRECT")
+		}
+		conds := make([]string, 0, len(rule.Domains))
+		for _, d := range rule.Domains {
+			conds = append(conds, domainCondition(strings.ToLower(d)))
+		}
+		fmt.Fprintf(&b, "  // %s\n", rule.selector())
+		fmt.Fprintf(&b, "  if (%s)\n", strings.Join(conds, " || "))
+		fmt.Fprintf(&b, "    return %s;\n", jsString(strings.Join(directives, "; ")))
+	}
+	fmt.Fprintf(&b, "\n  return %s;\n}\n", jsString(cmp.Or(opts.Default, "DIRECT")))
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (rule PACRule) selector() string {
+	var parts []string
+	if rule.Country != "" {
+		parts = append(parts, "country="+rule.Country)
+	}
+	if rule.Tag != "" {
+		parts = append(parts, "tag="+rule.Tag)
+	}
+	if rule.ConnectionType != "" {
+		parts = append(parts, "connection_type="+rule.ConnectionType)
+	}
+	if len(parts) == 0 {
+		return "any proxy"
+	}
+	return strings.Join(parts, " ")
+}
+
+// domainCondition matches a shell pattern as is, and a plain domain along
+// with its subdomains.
+func domainCondition(d string) string {
+	if strings.ContainsAny(d, "*?") {
+		return fmt.Sprintf("shExpMatch(host, %s)", jsString(d))
+	}
+	d = strings.TrimPrefix(d, ".")
+	return fmt.Sprintf("host == %s || dnsDomainIs(host, %s)", jsString(d), jsString("."+d))
+}
+
+func pacDirective(c models.CheckedProxy) string {
+	switch scheme(c) {
+	case "https":
+		return "HTTPS " + c.Proxy.Address()
+	case "socks5", "socks5h":
+		return "SOCKS5 " + c.Proxy.Address()
+	case "socks4", "socks4a":
+		return "SOCKS " + c.Proxy.Address()
+	}
+	return "PROXY " + c.Proxy.Address()
+}
+
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
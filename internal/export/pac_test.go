@@ -0,0 +1,58 @@
+package export
+
+import (
+	"ip-proxy-checker/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestRenderPAC(t *testing.T) {
+	list := []models.CheckedProxy{
+		{
+			Proxy:  models.ProxyInput{Host: "1.1.1.1", Port: "8080", Tags: []string{"supplier-a"}},
+			Result: models.IPQualityResult{Status: "Live", Protocol: "http", Country: "DE", LatencyMS: 300},
+		},
+		{
+			Proxy:  models.ProxyInput{Host: "2.2.2.2", Port: "1080"},
+			Result: models.IPQualityResult{Status: "Live", Protocol: "socks5", Country: "DE", LatencyMS: 120},
+		},
+		{
+			Proxy:  models.ProxyInput{Host: "3.3.3.3", Port: "8080"},
+			Result: models.IPQualityResult{Status: "Live", Protocol: "http", Country: "DE"},
+		},
+		{
+			Proxy:  models.ProxyInput{Host: "4.4.4.4", Port: "8080"},
+			Result: models.IPQualityResult{Status: "Dead", Country: "DE"},
+		},
+	}
+	opts := PACOptions{Rules: []PACRule{
+		{Domains: []string{"Example.de", "*.shop.*"}, Country: "de", Direct: true},
+		{Domains: []string{"supplier.example"}, Tag: "supplier-a", Failover: 1},
+		{Domains: []string{"example.fr"}, Country: "FR"},
+	}}
+	var b strings.Builder
+	if err := RenderPAC(&b, list, opts); err != nil {
+		t.Fatal(err)
+	}
+	pac := b.String()
+	for _, want := range []string{
+		`if (host == "example.de" || dnsDomainIs(host, ".example.de") || shExpMatch(host, "*.shop.*"))`,
+		`return "SOCKS5 2.2.2.2:1080; PROXY 1.1.1.1:8080; PROXY 3.3.3.3:8080; DIRECT";`,
+		`return "PROXY 1.1.1.1:8080";`,
+		`// example.fr: no live proxy matches country=FR`,
+		`return "DIRECT";`,
+		"from 3 live proxies",
+	} {
+		if !strings.Contains(pac, want) {
+			t.Errorf("PAC file lacks %q:\n%s", want, pac)
+		}
+	}
+	if strings.Contains(pac, "4.4.4.4") {
+		t.Errorf("PAC file routes through a dead proxy:\n%s", pac)
+	}
+
+	bad := PACOptions{Rules: []PACRule{{Domains: []string{`example.com"); alert(1`}}}}
+	if err := RenderPAC(&b, list, bad); err == nil {
+		t.Error("Expected an error for an invalid domain pattern")
+	}
+}
@@ -0,0 +1,385 @@
+// Package gateway is a local HTTP and SOCKS5 proxy that forwards every
+// connection through an upstream picked from the live proxy pool.
+package gateway
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"ip-proxy-checker/internal/models"
+	"ip-proxy-checker/internal/proxy"
+	"ip-proxy-checker/internal/storage"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Upstream selection strategies
+const (
+	RoundRobin   = "round-robin"
+	Random       = "random"
+	LeastLatency = "least-latency"
+	Sticky       = "sticky" // one upstream per session, picked at random
+)
+
+// Strategies lists the valid gateway strategies.
+var Strategies = []string{RoundRobin, Random, LeastLatency, Sticky}
+
+// ErrNoUpstream is returned when no healthy upstream is left to try.
+var ErrNoUpstream = errors.New("no healthy upstream proxy")
+
+// Source returns the checked proxies the gateway may use. Only live ones are.
+type Source func() []models.CheckedProxy
+
+// Target is what a client connection asked for, see ParseUsername.
+type Target struct {
+	Session        string // the session parameter, else the username or client address
+	Country        string // ISO 3166-1 alpha-2 code
+	ConnectionType string
+
+	pinned bool // Session came from a session parameter and pins an upstream under any strategy
+}
+
+type upstream struct {
+	key     string
+	checked models.CheckedProxy
+	client  *proxy.ProxyClient
+
+	// Guarded by Gateway.mu
+	latency      time.Duration // moving average of tunnel setup, seeded with the checked latency
+	failures     int           // consecutive
+	evictedUntil time.Time
+	conns        int64
+	errors       int64
+}
+
+type session struct {
+	up      *upstream
+	expires time.Time
+}
+
+// Gateway picks upstreams for client connections and tracks their health.
+type Gateway struct {
+	cfg    Config
+	source Source
+
+	mu        sync.Mutex
+	upstreams map[string]*upstream
+	order     []*upstream // by key, for a stable round-robin
+	next      int
+	sessions  map[string]*session
+	refreshed time.Time
+}
+
+// Config is the gateway section of the config with its defaults applied.
+type Config struct {
+	HTTPListen      string
+	SOCKSListen     string
+	Password        string
+	Strategy        string
+	SessionTTL      time.Duration
+	DialTimeout     time.Duration
+	Retries         int
+	MaxFailures     int
+	EvictFor        time.Duration
+	RefreshInterval time.Duration
+}
+
+// New validates the gateway config. Upstreams are loaded from source on the
+// first connection and every refresh interval after.
+func New(cfg *storage.Config, source Source) (*Gateway, error) {
+	c := cfg.Gateway
+	g := &Gateway{
+		cfg: Config{
+			HTTPListen:      c.HTTPListen,
+			SOCKSListen:     c.SOCKSListen,
+			Password:        c.Password,
+			Strategy:        cmp.Or(c.Strategy, RoundRobin),
+			SessionTTL:      cmp.Or(c.SessionTTL, 10*time.Minute),
+			DialTimeout:     cmp.Or(c.DialTimeout, 10*time.Second),
+			Retries:         max(c.Retries, 0),
+			MaxFailures:     cmp.Or(c.MaxFailures, 3),
+			EvictFor:        cmp.Or(c.EvictFor, 5*time.Minute),
+			RefreshInterval: cmp.Or(c.RefreshInterval, 30*time.Second),
+		},
+		source:    source,
+		upstreams: make(map[string]*upstream),
+		sessions:  make(map[string]*session),
+	}
+	if !slices.Contains(Strategies, g.cfg.Strategy) {
+		return nil, fmt.Errorf("invalid gateway strategy %q: use %s", g.cfg.Strategy, strings.Join(Strategies, ", "))
+	}
+	return g, nil
+}
+
+// Start listens on the configured addresses and serves until ctx is done.
+func (g *Gateway) Start(ctx context.Context) error {
+	var httpListener, socksListener net.Listener
+	var err error
+	if g.cfg.HTTPListen != "" {
+		if httpListener, err = net.Listen("tcp", g.cfg.HTTPListen); err != nil {
+			return err
+		}
+	}
+	if g.cfg.SOCKSListen != "" {
+		if socksListener, err = net.Listen("tcp", g.cfg.SOCKSListen); err != nil {
+			if httpListener != nil {
+				httpListener.Close()
+			}
+			return err
+		}
+	}
+	if httpListener != nil {
+		srv := g.httpServer()
+		go srv.Serve(httpListener)
+		go func() {
+			<-ctx.Done()
+			srv.Close()
+		}()
+	}
+	if socksListener != nil {
+		go g.serveSOCKS(socksListener)
+		go func() {
+			<-ctx.Done()
+			socksListener.Close()
+		}()
+	}
+	return nil
+}
+
+// Dial connects to addr through an upstream picked for t, trying up to
+// Retries other upstreams when the dial fails.
+func (g *Gateway) Dial(ctx context.Context, t Target, addr string) (net.Conn, error) {
+	var conn net.Conn
+	err := g.try(ctx, t, g.cfg.Retries+1, func(up *upstream) (time.Duration, error) {
+		dialCtx, cancel := context.WithTimeout(ctx, g.cfg.DialTimeout)
+		defer cancel()
+		start := time.Now()
+		var err error
+		conn, err = up.client.DialContext(dialCtx, "tcp", addr)
+		return time.Since(start), err
+	})
+	return conn, err
+}
+
+// try runs fn on up to attempts different upstreams until it succeeds. fn
+// returns the latency to record, or 0 when it measured none.
+func (g *Gateway) try(ctx context.Context, t Target, attempts int, fn func(up *upstream) (time.Duration, error)) error {
+	g.refreshIfStale()
+	tried := make(map[*upstream]bool)
+	var lastErr error
+	for range attempts {
+		up := g.pick(t, tried)
+		if up == nil {
+			break
+		}
+		tried[up] = true
+		latency, err := fn(up)
+		g.report(t, up, latency, err)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lastErr = err
+		log.Debug().Err(err).Str("upstream", up.client.Proxy.Host).Msg("Gateway upstream failed")
+	}
+	if lastErr == nil {
+		if sel := t.String(); sel != "" {
+			return fmt.Errorf("%w matches %s", ErrNoUpstream, sel)
+		}
+		return ErrNoUpstream
+	}
+	return lastErr
+}
+
+// pick chooses a healthy upstream matching t that was not tried yet. A
+// session keeps its upstream until the upstream fails or the TTL runs out.
+func (g *Gateway) pick(t Target, tried map[*upstream]bool) *upstream {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	var healthy []*upstream
+	for _, up := range g.order {
+		if !tried[up] && now.After(up.evictedUntil) && t.matches(up.checked) {
+			healthy = append(healthy, up)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	if t.Session != "" && (t.pinned || g.cfg.Strategy == Sticky) {
+		if s, ok := g.sessions[t.Session]; ok && now.Before(s.expires) && slices.Contains(healthy, s.up) {
+			return s.up
+		}
+		up := healthy[rand.IntN(len(healthy))]
+		g.sessions[t.Session] = &session{up: up, expires: now.Add(g.cfg.SessionTTL)}
+		return up
+	}
+
+	switch g.cfg.Strategy {
+	case Random:
+		return healthy[rand.IntN(len(healthy))]
+	case LeastLatency:
+		return slices.MinFunc(healthy, func(a, b *upstream) int {
+			return cmp.Compare(rankLatency(a), rankLatency(b))
+		})
+	}
+	up := healthy[g.next%len(healthy)]
+	g.next++
+	return up
+}
+
+// rankLatency puts upstreams without a measured latency last.
+func rankLatency(up *upstream) time.Duration {
+	if up.latency == 0 {
+		return time.Duration(1<<63 - 1)
+	}
+	return up.latency
+}
+
+// report records the outcome of a connection through up, evicting it after
+// MaxFailures consecutive failures.
+func (g *Gateway) report(t Target, up *upstream, latency time.Duration, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err == nil {
+		up.failures = 0
+		up.conns++
+		if latency > 0 {
+			if up.latency == 0 {
+				up.latency = latency
+			} else {
+				up.latency = (3*up.latency + latency) / 4
+			}
+		}
+		return
+	}
+
+	up.failures++
+	up.errors++
+	if s, ok := g.sessions[t.Session]; ok && s.up == up {
+		delete(g.sessions, t.Session)
+	}
+	if up.failures >= g.cfg.MaxFailures {
+		up.failures = 0
+		up.evictedUntil = time.Now().Add(g.cfg.EvictFor)
+		log.Warn().Str("upstream", up.client.Proxy.Host).Dur("evict_for", g.cfg.EvictFor).Msg("Gateway upstream evicted")
+	}
+}
+
+func (g *Gateway) refreshIfStale() {
+	g.mu.Lock()
+	stale := time.Since(g.refreshed) >= g.cfg.RefreshInterval
+	g.mu.Unlock()
+	if stale {
+		g.Refresh()
+	}
+}
+
+// Refresh reloads the live pool from the source. Upstreams still in it keep
+// their health state.
+func (g *Gateway) Refresh() {
+	list := g.source()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	next := make(map[string]*upstream, len(list))
+	for _, c := range list {
+		if c.Result.Status != "Live" {
+			continue
+		}
+		key := c.Proxy.Normalized()
+		up, ok := g.upstreams[key]
+		if !ok {
+			input := c.Proxy
+			input.Scheme = cmp.Or(c.Result.Protocol, input.Scheme)
+			client, err := proxy.NewProxyClientFromInput(input, "", g.cfg.DialTimeout)
+			if err != nil {
+				continue
+			}
+			up = &upstream{key: key, client: client, latency: time.Duration(c.Result.LatencyMS) * time.Millisecond}
+		}
+		up.checked = c
+		next[key] = up
+	}
+
+	g.upstreams = next
+	g.order = g.order[:0]
+	for _, up := range next {
+		g.order = append(g.order, up)
+	}
+	slices.SortFunc(g.order, func(a, b *upstream) int { return strings.Compare(a.key, b.key) })
+	now := time.Now()
+	for id, s := range g.sessions {
+		if now.After(s.expires) || next[s.up.key] != s.up {
+			delete(g.sessions, id)
+		}
+	}
+	g.refreshed = now
+}
+
+// UpstreamStatus is the health of one upstream. Credentials are never shown.
+type UpstreamStatus struct {
+	Proxy          string     `json:"proxy"` // scheme://host:port
+	Label          string     `json:"label,omitempty"`
+	Country        string     `json:"country,omitempty"`
+	ConnectionType string     `json:"connection_type,omitempty"`
+	LatencyMS      int64      `json:"latency_ms"`
+	Connections    int64      `json:"connections"`
+	Errors         int64      `json:"errors"`
+	EvictedUntil   *time.Time `json:"evicted_until,omitempty"`
+}
+
+// Status is the state of the gateway and its upstreams.
+type Status struct {
+	Strategy    string           `json:"strategy"`
+	HTTPListen  string           `json:"http_listen,omitempty"`
+	SOCKSListen string           `json:"socks_listen,omitempty"`
+	Healthy     int              `json:"healthy"`
+	Sessions    int              `json:"sessions"`
+	Upstreams   []UpstreamStatus `json:"upstreams"`
+}
+
+func (g *Gateway) Status() Status {
+	g.refreshIfStale()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	st := Status{
+		Strategy:    g.cfg.Strategy,
+		HTTPListen:  g.cfg.HTTPListen,
+		SOCKSListen: g.cfg.SOCKSListen,
+		Sessions:    len(g.sessions),
+		Upstreams:   make([]UpstreamStatus, 0, len(g.order)),
+	}
+	for _, up := range g.order {
+		us := UpstreamStatus{
+			Proxy:          up.client.Proxy.Scheme + "://" + up.client.Proxy.Host,
+			Label:          up.checked.Proxy.Label,
+			Country:        cmp.Or(up.checked.Result.CountryCode, up.checked.Result.Country),
+			ConnectionType: up.checked.Result.ConnectionType,
+			LatencyMS:      up.latency.Milliseconds(),
+			Connections:    up.conns,
+			Errors:         up.errors,
+		}
+		if now.Before(up.evictedUntil) {
+			until := up.evictedUntil
+			us.EvictedUntil = &until
+		} else {
+			st.Healthy++
+		}
+		st.Upstreams = append(st.Upstreams, us)
+	}
+	return st
+}
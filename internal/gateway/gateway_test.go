@@ -0,0 +1,281 @@
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"ip-proxy-checker/internal/models"
+	"ip-proxy-checker/internal/storage"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// echoServer answers every connection with what it reads.
+func echoServer(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// connectProxy is a minimal HTTP proxy serving CONNECT and absolute URLs.
+func connectProxy(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			out := r.Clone(r.Context())
+			out.RequestURI = ""
+			resp, err := http.DefaultTransport.RoundTrip(out)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		client, _, _ := w.(http.Hijacker).Hijack()
+		io.WriteString(client, "HTTP/1.1 200 OK\r\n\r\n")
+		pipe(client, target)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func liveProxy(scheme, addr string, latency int64) models.CheckedProxy {
+	host, port, _ := net.SplitHostPort(addr)
+	return models.CheckedProxy{
+		Proxy:  models.ProxyInput{Scheme: scheme, Host: host, Port: port},
+		Result: models.IPQualityResult{Status: "Live", Protocol: scheme, LatencyMS: latency},
+	}
+}
+
+func newGateway(t *testing.T, configure func(cfg *storage.Config), pool ...models.CheckedProxy) *Gateway {
+	cfg, _ := storage.LoadConfig("")
+	cfg.Gateway.DialTimeout = 2 * time.Second
+	configure(cfg)
+	g, err := New(cfg, func() []models.CheckedProxy { return pool })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func echo(t *testing.T, conn net.Conn, msg string) {
+	t.Helper()
+	defer conn.Close()
+	if _, err := io.WriteString(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != msg {
+		t.Fatalf("Echo returned %q, %v", buf, err)
+	}
+}
+
+func TestGatewayRetriesAndEvicts(t *testing.T) {
+	target := echoServer(t)
+	good := connectProxy(t)
+	g := newGateway(t, func(cfg *storage.Config) {
+		cfg.Gateway.Strategy = LeastLatency
+		cfg.Gateway.Retries = 1
+		cfg.Gateway.MaxFailures = 2
+	},
+		liveProxy("socks5", deadAddr(t), 10), // fastest, so always tried first
+		liveProxy("http", good.Listener.Addr().String(), 200),
+	)
+
+	for i := range 3 {
+		conn, err := g.Dial(context.Background(), Target{}, target)
+		if err != nil {
+			t.Fatalf("Dial %d failed: %v", i, err)
+		}
+		echo(t, conn, "ping")
+	}
+
+	st := g.Status()
+	if st.Healthy != 1 || len(st.Upstreams) != 2 {
+		t.Fatalf("Unexpected status %+v", st)
+	}
+	for _, up := range st.Upstreams {
+		if up.EvictedUntil == nil && up.Connections != 3 {
+			t.Errorf("Healthy upstream served %d connections, want 3", up.Connections)
+		}
+		if up.EvictedUntil != nil && up.Errors != 2 {
+			t.Errorf("Evicted upstream has %d errors, want 2", up.Errors)
+		}
+	}
+}
+
+func TestGatewayForwardFailsOverUpstreamErrors(t *testing.T) {
+	var refused atomic.Int32
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refused.Add(1)
+		w.Header().Set("Proxy-Status", "refusing; error=destination_unavailable")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer refusing.Close()
+	good := connectProxy(t)
+	gateway := func() *Gateway {
+		return newGateway(t, func(cfg *storage.Config) {
+			cfg.Gateway.Strategy = LeastLatency
+			cfg.Gateway.Retries = 1
+			cfg.Gateway.MaxFailures = 1
+		},
+			liveProxy("http", refusing.Listener.Addr().String(), 10), // fastest, so tried first
+			liveProxy("http", good.Listener.Addr().String(), 200),
+		)
+	}
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		io.WriteString(w, "plain")
+	}))
+	defer web.Close()
+	forward := func(g *Gateway, req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		g.forward(rec, req, Target{})
+		return rec
+	}
+
+	g := gateway()
+	if rec := forward(g, httptest.NewRequest(http.MethodGet, web.URL, nil)); rec.Code != http.StatusOK || rec.Body.String() != "plain" {
+		t.Fatalf("Forward = %d %q, want the retry on the healthy upstream", rec.Code, rec.Body)
+	}
+	// An origin error is relayed and never held against the upstream
+	if rec := forward(g, httptest.NewRequest(http.MethodGet, web.URL+"/down", nil)); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Origin 503 forwarded as %d", rec.Code)
+	}
+	for _, up := range g.Status().Upstreams {
+		if evicted := up.EvictedUntil != nil; evicted != (up.LatencyMS == 10) || !evicted && up.Errors != 0 {
+			t.Errorf("Upstream %s evicted = %v with %d errors", up.Proxy, evicted, up.Errors)
+		}
+	}
+
+	// A POST is sent once, even without a body
+	refused.Store(0)
+	if rec := forward(gateway(), httptest.NewRequest(http.MethodPost, web.URL, nil)); rec.Code != http.StatusBadGateway || refused.Load() != 1 {
+		t.Errorf("Refused POST forwarded as %d after %d attempts", rec.Code, refused.Load())
+	}
+}
+
+func TestGatewayStickySessions(t *testing.T) {
+	a, b := connectProxy(t), connectProxy(t)
+	g := newGateway(t, func(cfg *storage.Config) { cfg.Gateway.Strategy = Sticky },
+		liveProxy("http", a.Listener.Addr().String(), 0),
+		liveProxy("http", b.Listener.Addr().String(), 0),
+	)
+	g.Refresh()
+	first := g.pick(Target{Session: "abc"}, nil)
+	for range 10 {
+		if up := g.pick(Target{Session: "abc"}, nil); up != first {
+			t.Fatal("Sticky session switched upstreams")
+		}
+	}
+	g.report(Target{Session: "abc"}, first, 0, io.EOF)
+	if _, ok := g.sessions["abc"]; ok {
+		t.Error("Failed upstream is still pinned")
+	}
+}
+
+func TestGatewayServesHTTPAndSOCKS(t *testing.T) {
+	target := echoServer(t)
+	upstream := connectProxy(t)
+	g := newGateway(t, func(cfg *storage.Config) { cfg.Gateway.Password = "secret" },
+		liveProxy("http", upstream.Listener.Addr().String(), 50),
+	)
+
+	gw := httptest.NewServer(g)
+	defer gw.Close()
+	web := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello")
+	}))
+	defer web.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "plain")
+	}))
+	defer plain.Close()
+
+	proxyURL, _ := url.Parse(gw.URL)
+	proxyURL.User = url.UserPassword("session-1", "secret")
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+	for _, tc := range []struct{ url, want string }{{web.URL, "hello"}, {plain.URL, "plain"}} {
+		resp, err := client.Get(tc.url)
+		if err != nil {
+			t.Fatalf("GET %s through the gateway: %v", tc.url, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != tc.want {
+			t.Errorf("GET %s = %q, want %q", tc.url, body, tc.want)
+		}
+	}
+
+	proxyURL.User = url.UserPassword("session-1", "wrong")
+	resp, err := client.Get(plain.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Errorf("Wrong password got %s", resp.Status)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go g.serveSOCKS(l)
+	dialer, _ := proxy.SOCKS5("tcp", l.Addr().String(), &proxy.Auth{User: "session-2", Password: "secret"}, proxy.Direct)
+	conn, err := dialer.Dial("tcp", target)
+	if err != nil {
+		t.Fatalf("SOCKS5 dial through the gateway: %v", err)
+	}
+	echo(t, conn, "socks")
+
+	dialer, _ = proxy.SOCKS5("tcp", l.Addr().String(), &proxy.Auth{User: "session-2", Password: "wrong"}, proxy.Direct)
+	if _, err := dialer.Dial("tcp", target); err == nil {
+		t.Error("Expected a SOCKS5 auth failure")
+	}
+}
@@ -0,0 +1,172 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Hop-by-hop headers, dropped when a request or response is forwarded
+var hopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func (g *Gateway) httpServer() *http.Server {
+	return &http.Server{Handler: g, ReadHeaderTimeout: 30 * time.Second}
+}
+
+// ServeHTTP tunnels CONNECT requests and forwards plain HTTP requests.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, _ := parseProxyAuth(r.Header.Get("Proxy-Authorization"))
+	t, err := g.authenticate(user, pass, r.RemoteAddr)
+	if err != nil {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy gateway"`)
+		http.Error(w, err.Error(), http.StatusProxyAuthRequired)
+		return
+	}
+	if r.Method == http.MethodConnect {
+		g.tunnel(w, r, t)
+		return
+	}
+	if !r.URL.IsAbs() || r.URL.Scheme != "http" {
+		http.Error(w, "only absolute http:// URLs and CONNECT can be proxied", http.StatusBadRequest)
+		return
+	}
+	g.forward(w, r, t)
+}
+
+func (g *Gateway) tunnel(w http.ResponseWriter, r *http.Request, t Target) {
+	upstream, err := g.Dial(r.Context(), t, r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	client, rw, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+	var clientConn net.Conn = client
+	if rw.Reader.Buffered() > 0 {
+		clientConn = &bufferedConn{Conn: client, r: rw.Reader}
+	}
+	pipe(clientConn, upstream)
+}
+
+// forward sends a plain HTTP request through an upstream's own transport.
+// An HTTP proxy upstream refusing the request failed, as a refused CONNECT
+// does. Only idempotent requests without a body are retried on another
+// upstream: a body was already consumed, and others may have taken effect.
+func (g *Gateway) forward(w http.ResponseWriter, r *http.Request, t Target) {
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	attempts := g.cfg.Retries + 1
+	hasBody := r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0
+	if hasBody || !slices.Contains(idempotentMethods, r.Method) {
+		attempts = 1
+	}
+
+	var resp *http.Response
+	err := g.try(r.Context(), t, attempts, func(up *upstream) (time.Duration, error) {
+		var err error
+		resp, err = up.client.HTTPClient.Transport.RoundTrip(out)
+		if err != nil {
+			return 0, err
+		}
+		if upstreamFailed(up, resp) {
+			resp.Body.Close()
+			return 0, fmt.Errorf("upstream proxy answered %s", resp.Status)
+		}
+		return 0, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+var idempotentMethods = []string{http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete}
+
+// upstreamFailed reports whether the response was generated by an HTTP proxy
+// upstream itself rather than the origin: a 407, or a 5xx carrying the
+// Proxy-Status header (RFC 9209). A SOCKS upstream only relays the origin.
+func upstreamFailed(up *upstream, resp *http.Response) bool {
+	if !strings.HasPrefix(up.client.Proxy.Scheme, "http") {
+		return false
+	}
+	return resp.StatusCode == http.StatusProxyAuthRequired ||
+		resp.StatusCode >= 500 && resp.Header.Get("Proxy-Status") != ""
+}
+
+// authenticate checks the gateway password and returns the target the
+// client asked for in its username.
+func (g *Gateway) authenticate(user, pass, remoteAddr string) (Target, error) {
+	if g.cfg.Password != "" && subtle.ConstantTimeCompare([]byte(pass), []byte(g.cfg.Password)) != 1 {
+		return Target{}, errors.New("proxy authentication required")
+	}
+	t, err := ParseUsername(user)
+	if err != nil {
+		return Target{}, err
+	}
+	if t.Session == "" {
+		t.Session, _, _ = net.SplitHostPort(remoteAddr)
+	}
+	return t, nil
+}
+
+func parseProxyAuth(header string) (string, string, bool) {
+	if header == "" {
+		return "", "", false
+	}
+	// Same encoding as Authorization: Basic
+	r := http.Request{Header: http.Header{"Authorization": {header}}}
+	return r.BasicAuth()
+}
+
+// pipe copies between a and b until either side is done, then closes both.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
@@ -0,0 +1,218 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SOCKS5 constants (RFC 1928, RFC 1929)
+const (
+	socksVersion     = 5
+	authNone         = 0x00
+	authPassword     = 0x02
+	authNoAcceptable = 0xff
+	cmdConnect       = 0x01
+	atypIPv4         = 0x01
+	atypDomain       = 0x03
+	atypIPv6         = 0x04
+
+	repSuccess         = 0x00
+	repGeneralFailure  = 0x01
+	repCmdNotSupported = 0x07
+	repAtypUnsupported = 0x08
+
+	socksHandshakeTimeout = 30 * time.Second
+)
+
+func (g *Gateway) serveSOCKS(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Error().Err(err).Msg("Gateway SOCKS listener failed")
+			}
+			return
+		}
+		go g.handleSOCKS(conn)
+	}
+}
+
+// handleSOCKS serves one SOCKS5 CONNECT. Other commands are refused.
+func (g *Gateway) handleSOCKS(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	br := bufio.NewReader(conn)
+
+	t, err := g.socksAuth(conn, br)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	addr, rep, err := readSOCKSRequest(br)
+	if err != nil {
+		if rep != repSuccess {
+			writeSOCKSReply(conn, rep)
+		}
+		conn.Close()
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), socksHandshakeTimeout)
+	upstream, err := g.Dial(ctx, t, addr)
+	cancel()
+	if err != nil {
+		writeSOCKSReply(conn, repGeneralFailure)
+		conn.Close()
+		return
+	}
+	if err := writeSOCKSReply(conn, repSuccess); err != nil {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	var client net.Conn = conn
+	if br.Buffered() > 0 {
+		client = &bufferedConn{Conn: conn, r: br}
+	}
+	pipe(client, upstream)
+}
+
+// socksAuth negotiates the auth method and authenticates the client.
+// Username and password are asked for whenever the client offers them,
+// since the username names the session.
+func (g *Gateway) socksAuth(conn net.Conn, br *bufio.Reader) (Target, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return Target{}, err
+	}
+	if head[0] != socksVersion {
+		return Target{}, errors.New("not SOCKS5")
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(br, methods); err != nil {
+		return Target{}, err
+	}
+
+	method := byte(authNoAcceptable)
+	for _, m := range methods {
+		if m == authPassword {
+			method = authPassword
+			break
+		}
+		if m == authNone && g.cfg.Password == "" {
+			method = authNone
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return Target{}, err
+	}
+	switch method {
+	case authNone:
+		return g.authenticate("", "", conn.RemoteAddr().String())
+	case authNoAcceptable:
+		return Target{}, errors.New("no acceptable auth method")
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	readField := func() (string, error) {
+		n, err := br.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(br, b)
+		return string(b), err
+	}
+	if ver, err := br.ReadByte(); err != nil || ver != 1 {
+		return Target{}, errors.New("bad auth version")
+	}
+	user, err := readField()
+	if err != nil {
+		return Target{}, err
+	}
+	pass, err := readField()
+	if err != nil {
+		return Target{}, err
+	}
+	t, authErr := g.authenticate(user, pass, conn.RemoteAddr().String())
+	status := byte(0)
+	if authErr != nil {
+		status = 1
+	}
+	if _, err := conn.Write([]byte{1, status}); err != nil {
+		return Target{}, err
+	}
+	return t, authErr
+}
+
+// readSOCKSRequest returns the host:port of a CONNECT request, or the reply
+// code to refuse it with.
+func readSOCKSRequest(br *bufio.Reader) (string, byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return "", repSuccess, err
+	}
+	if head[0] != socksVersion {
+		return "", repSuccess, errors.New("not SOCKS5")
+	}
+	if head[1] != cmdConnect {
+		return "", repCmdNotSupported, errors.New("unsupported command")
+	}
+
+	var host string
+	switch head[3] {
+	case atypIPv4, atypIPv6:
+		b := make([]byte, 4)
+		if head[3] == atypIPv6 {
+			b = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(br, b); err != nil {
+			return "", repSuccess, err
+		}
+		ip, _ := netip.AddrFromSlice(b)
+		host = ip.String()
+	case atypDomain:
+		n, err := br.ReadByte()
+		if err != nil {
+			return "", repSuccess, err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return "", repSuccess, err
+		}
+		host = string(b)
+	default:
+		return "", repAtypUnsupported, errors.New("unsupported address type")
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(br, port[:]); err != nil {
+		return "", repSuccess, err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), repSuccess, nil
+}
+
+// writeSOCKSReply answers with an unspecified bind address.
+func writeSOCKSReply(conn net.Conn, rep byte) error {
+	_, err := conn.Write([]byte{socksVersion, rep, 0, atypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// bufferedConn reads what a bufio.Reader already buffered before the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,104 @@
+package gateway
+
+import (
+	"fmt"
+	"ip-proxy-checker/internal/checker"
+	"ip-proxy-checker/internal/models"
+	"slices"
+	"strings"
+)
+
+// Username parameters
+const (
+	paramCountry = "country"
+	paramSession = "session"
+	paramType    = "type"
+)
+
+var connectionTypes = []string{checker.ConnResidential, checker.ConnMobile, checker.ConnDatacenter, checker.ConnBusiness}
+
+// ParseUsername reads the targeting parameters of a proxy username of the
+// form <name>-country-<country>-session-<id>-type-<connection type>, each
+// parameter optional and in any order, e.g. user-country-us-session-abc.
+// The name ends at the first parameter key and a session id at the next one,
+// so both may contain dashes themselves. A country is an ISO code or a name
+// with underscores for spaces (united_states). A username without parameters
+// only names the session of the sticky strategy.
+func ParseUsername(user string) (Target, error) {
+	parts := strings.Split(user, "-")
+	start := slices.IndexFunc(parts[1:], isParam)
+	if start < 0 {
+		return Target{Session: user}, nil
+	}
+	params := parts[start+1:]
+
+	var t Target
+	seen := make(map[string]bool)
+	for i := 0; i < len(params); {
+		key := params[i]
+		if !isParam(key) {
+			return Target{}, fmt.Errorf("unknown username parameter %q", key)
+		}
+		if seen[key] {
+			return Target{}, fmt.Errorf("username parameter %q is repeated", key)
+		}
+		seen[key] = true
+		end := i + 2
+		if key == paramSession {
+			end = len(params)
+			if next := slices.IndexFunc(params[i+1:], isParam); next >= 0 {
+				end = i + 1 + next
+			}
+		}
+		var value string
+		if end <= len(params) {
+			value = strings.Join(params[i+1:end], "-")
+		}
+		i = end
+		if value == "" {
+			return Target{}, fmt.Errorf("username parameter %q has no value", key)
+		}
+		switch key {
+		case paramCountry:
+			code, _, ok := models.LookupCountry(value)
+			if !ok {
+				return Target{}, fmt.Errorf("unknown country %q: use an ISO code such as us", value)
+			}
+			t.Country = code
+		case paramSession:
+			t.Session, t.pinned = value, true
+		case paramType:
+			value = strings.ToLower(value)
+			if !slices.Contains(connectionTypes, value) {
+				return Target{}, fmt.Errorf("invalid connection type %q: use %s", value, strings.Join(connectionTypes, ", "))
+			}
+			t.ConnectionType = value
+		}
+	}
+	if !t.pinned {
+		t.Session = user
+	}
+	return t, nil
+}
+
+func isParam(p string) bool {
+	return p == paramCountry || p == paramSession || p == paramType
+}
+
+// matches reports whether a checked proxy has the country and connection
+// type the target asked for.
+func (t Target) matches(c models.CheckedProxy) bool {
+	return (t.Country == "" || c.Result.InCountry(t.Country)) &&
+		(t.ConnectionType == "" || t.ConnectionType == c.Result.ConnectionType)
+}
+
+func (t Target) String() string {
+	var parts []string
+	if t.Country != "" {
+		parts = append(parts, "country="+t.Country)
+	}
+	if t.ConnectionType != "" {
+		parts = append(parts, "type="+t.ConnectionType)
+	}
+	return strings.Join(parts, " ")
+}
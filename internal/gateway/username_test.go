@@ -0,0 +1,85 @@
+package gateway
+
+import (
+	"io"
+	"ip-proxy-checker/internal/models"
+	"ip-proxy-checker/internal/storage"
+	"testing"
+)
+
+func TestParseUsername(t *testing.T) {
+	tests := []struct {
+		user string
+		want Target
+		err  bool
+	}{
+		{"alice", Target{Session: "alice"}, false},
+		{"qa-team", Target{Session: "qa-team"}, false},
+		{"user-country-us-session-abc-type-residential", Target{Session: "abc", Country: "US", ConnectionType: "residential", pinned: true}, false},
+		{"qa-team-type-Mobile-country-united_states", Target{Session: "qa-team-type-Mobile-country-united_states", Country: "US", ConnectionType: "mobile"}, false},
+		{"user-session-a1-b2--c3-country-Germany", Target{Session: "a1-b2--c3", Country: "DE", pinned: true}, false},
+		{"user-country-gb-session-x-y", Target{Session: "x-y", Country: "GB", pinned: true}, false},
+		{"user-country-atlantis", Target{}, true},
+		{"user-country", Target{}, true},
+		{"user-country-us-country-de", Target{}, true},
+		{"user-type-satellite", Target{}, true},
+		{"user-country-us-city-berlin", Target{}, true},
+		{"user-session--country-us", Target{}, true},
+		{"user-session", Target{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseUsername(tt.user)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseUsername(%q) error = %v, want error %v", tt.user, err, tt.err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("ParseUsername(%q) = %+v, want %+v", tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestGatewayTargetsCountryAndSession(t *testing.T) {
+	proxy := func(host, country, connType string) models.CheckedProxy {
+		return models.CheckedProxy{
+			Proxy:  models.ProxyInput{Scheme: "http", Host: host, Port: "8080"},
+			Result: models.IPQualityResult{Status: "Live", Country: country, ConnectionClass: models.ConnectionClass{ConnectionType: connType}},
+		}
+	}
+	g := newGateway(t, func(cfg *storage.Config) { cfg.Gateway.Strategy = RoundRobin },
+		proxy("1.1.1.1", "US", "residential"),
+		proxy("2.2.2.2", "US", "datacenter"),
+		proxy("3.3.3.3", "DE", "residential"),
+		proxy("4.4.4.4", "US", "residential"),
+	)
+	g.Refresh()
+
+	target, _ := ParseUsername("user-country-us-type-residential")
+	seen := make(map[string]bool)
+	for range 4 {
+		up := g.pick(target, nil)
+		if up.checked.Result.Country != "US" || up.checked.Result.ConnectionType != "residential" {
+			t.Fatalf("Picked %s for %s", up.key, target)
+		}
+		seen[up.checked.Proxy.Host] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("Round-robin over matching upstreams used %v", seen)
+	}
+
+	pinned, _ := ParseUsername("user-country-us-session-abc")
+	first := g.pick(pinned, nil)
+	for range 5 {
+		if up := g.pick(pinned, nil); up != first {
+			t.Fatal("Session parameter did not pin the upstream under round-robin")
+		}
+	}
+	g.report(pinned, first, 0, io.EOF)
+	if up := g.pick(pinned, map[*upstream]bool{first: true}); up == first || up.checked.Result.Country != "US" {
+		t.Errorf("Failed session moved to %+v", up)
+	}
+
+	if err := g.try(t.Context(), Target{Country: "FR"}, 1, nil); err == nil || err.Error() != "no healthy upstream proxy matches country=FR" {
+		t.Errorf("Unexpected error for an unmatched country: %v", err)
+	}
+}
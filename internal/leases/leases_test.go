@@ -0,0 +1,83 @@
+package leases
+
+import (
+	"context"
+	"errors"
+	"ip-proxy-checker/internal/models"
+	"ip-proxy-checker/internal/storage"
+	"testing"
+	"time"
+)
+
+func checked(host, country, asn, score string, latency int64, tags ...string) models.CheckedProxy {
+	return models.CheckedProxy{
+		Proxy: models.ProxyInput{Host: host, Port: "8080", Username: "u", Password: "p", Tags: tags},
+		Result: models.IPQualityResult{
+			Status:     "Live",
+			Protocol:   "http",
+			Country:    country,
+			ASN:        asn,
+			FraudScore: score,
+			LatencyMS:  latency,
+		},
+	}
+}
+
+func TestLeaseByCriteria(t *testing.T) {
+	source := []models.CheckedProxy{
+		checked("1.1.1.1", "US", "AS7922", "20", 300, "supplier-a"),
+		checked("2.2.2.2", "US", "7922", "10", 100, "supplier-a", "premium"),
+		checked("3.3.3.3", "DE", "AS3320", "5", 50),
+		checked("4.4.4.4", "US", "AS7922", "90", 20),
+	}
+	cfg, _ := storage.LoadConfig("")
+	var rechecked []models.ProxyInput
+	pool := New(cfg, func() []models.CheckedProxy { return source }, func(ctx context.Context, proxies []models.ProxyInput) {
+		rechecked = append(rechecked, proxies...)
+	})
+
+	score := 50
+	c := Criteria{Country: "us", ASN: "7922", MaxFraudScore: &score, MaxLatencyMS: 500, Protocol: "HTTP", Tags: []string{"supplier-a"}}
+	first, err := pool.Lease(c, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Proxy.Host != "2.2.2.2" || first.URL != "http://u:p@2.2.2.2:8080" {
+		t.Errorf("Expected the fastest match, got %+v", first)
+	}
+	second, err := pool.Lease(c, 0)
+	if err != nil || second.Proxy.Host != "1.1.1.1" {
+		t.Fatalf("Second lease = %+v, %v", second, err)
+	}
+	if got := time.Until(second.ExpiresAt); got < 9*time.Minute {
+		t.Errorf("Default TTL not applied, expires in %s", got)
+	}
+	if _, err := pool.Lease(c, 0); !errors.Is(err, ErrNoProxy) {
+		t.Errorf("Expected ErrNoProxy while every match is leased, got %v", err)
+	}
+	if _, err := pool.Lease(c, 48*time.Hour); err == nil || errors.Is(err, ErrNoProxy) {
+		t.Errorf("Expected a TTL error, got %v", err)
+	}
+
+	if !pool.Release(second.ID) || pool.Release(second.ID) {
+		t.Error("Release should succeed exactly once")
+	}
+	if !pool.ReportBad(first.ID, "blocked") {
+		t.Fatal("ReportBad failed")
+	}
+	if st := pool.Status(); st.Live != 4 || st.Leased != 0 || st.Bad != 1 || st.Queued != 1 {
+		t.Errorf("Unexpected status %+v", st)
+	}
+	again, err := pool.Lease(c, 0)
+	if err != nil || again.Proxy.Host != "1.1.1.1" {
+		t.Fatalf("Bad proxy was leased again: %+v, %v", again, err)
+	}
+
+	pool.runRecheck(context.Background(), []models.ProxyInput{first.Proxy})
+	if len(rechecked) != 1 || rechecked[0].Host != "2.2.2.2" {
+		t.Errorf("Rechecked %v", rechecked)
+	}
+	if st := pool.Status(); st.Bad != 0 || st.Checked != 1 {
+		t.Errorf("Bad mark not lifted after the recheck: %+v", st)
+	}
+}
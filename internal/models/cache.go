@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// CacheInfo tells the client whether a result was served from the local cache.
+type CacheInfo struct {
+	Cached   bool  `json:"cached,omitempty"`
+	CacheAge int64 `json:"cache_age,omitempty"` // seconds since the result was fetched
+}
+
+func (c *CacheInfo) MarkCached(age time.Duration) {
+	c.Cached = true
+	c.CacheAge = int64(age.Seconds())
+}
@@ -0,0 +1,8 @@
+package models
+
+// ConnectionClass is the outcome of connection-type classification for an IP.
+type ConnectionClass struct {
+	ConnectionType       string   `json:"connection_type,omitempty"` // "residential", "mobile", "datacenter", "business", "unknown"
+	ConnectionConfidence float64  `json:"connection_confidence,omitempty"`
+	ConnectionSignals    []string `json:"connection_signals,omitempty"`
+}
@@ -0,0 +1,301 @@
+package models
+
+import "strings"
+
+// countries maps ISO 3166-1 alpha-2 codes to their English name, followed by
+// the other names providers are known to use.
+var countries = map[string][]string{
+	"AD": {"Andorra"},
+	"AE": {"United Arab Emirates", "UAE"},
+	"AF": {"Afghanistan"},
+	"AG": {"Antigua and Barbuda"},
+	"AI": {"Anguilla"},
+	"AL": {"Albania"},
+	"AM": {"Armenia"},
+	"AO": {"Angola"},
+	"AQ": {"Antarctica"},
+	"AR": {"Argentina"},
+	"AS": {"American Samoa"},
+	"AT": {"Austria"},
+	"AU": {"Australia"},
+	"AW": {"Aruba"},
+	"AX": {"Aland Islands", "Åland Islands", "Åland"},
+	"AZ": {"Azerbaijan"},
+	"BA": {"Bosnia and Herzegovina", "Bosnia"},
+	"BB": {"Barbados"},
+	"BD": {"Bangladesh"},
+	"BE": {"Belgium"},
+	"BF": {"Burkina Faso"},
+	"BG": {"Bulgaria"},
+	"BH": {"Bahrain"},
+	"BI": {"Burundi"},
+	"BJ": {"Benin"},
+	"BL": {"Saint Barthelemy", "Saint Barthélemy"},
+	"BM": {"Bermuda"},
+	"BN": {"Brunei", "Brunei Darussalam"},
+	"BO": {"Bolivia", "Bolivia, Plurinational State of"},
+	"BQ": {"Caribbean Netherlands", "Bonaire, Sint Eustatius and Saba", "Bonaire"},
+	"BR": {"Brazil"},
+	"BS": {"Bahamas", "The Bahamas"},
+	"BT": {"Bhutan"},
+	"BV": {"Bouvet Island"},
+	"BW": {"Botswana"},
+	"BY": {"Belarus"},
+	"BZ": {"Belize"},
+	"CA": {"Canada"},
+	"CC": {"Cocos (Keeling) Islands", "Cocos Islands"},
+	"CD": {"DR Congo", "Democratic Republic of the Congo", "Congo, The Democratic Republic of the", "Congo (Kinshasa)"},
+	"CF": {"Central African Republic"},
+	"CG": {"Republic of the Congo", "Congo", "Congo (Brazzaville)"},
+	"CH": {"Switzerland"},
+	"CI": {"Ivory Coast", "Côte d'Ivoire", "Cote d'Ivoire"},
+	"CK": {"Cook Islands"},
+	"CL": {"Chile"},
+	"CM": {"Cameroon"},
+	"CN": {"China"},
+	"CO": {"Colombia"},
+	"CR": {"Costa Rica"},
+	"CU": {"Cuba"},
+	"CV": {"Cape Verde", "Cabo Verde"},
+	"CW": {"Curacao", "Curaçao"},
+	"CX": {"Christmas Island"},
+	"CY": {"Cyprus"},
+	"CZ": {"Czechia", "Czech Republic"},
+	"DE": {"Germany"},
+	"DJ": {"Djibouti"},
+	"DK": {"Denmark"},
+	"DM": {"Dominica"},
+	"DO": {"Dominican Republic"},
+	"DZ": {"Algeria"},
+	"EC": {"Ecuador"},
+	"EE": {"Estonia"},
+	"EG": {"Egypt"},
+	"EH": {"Western Sahara"},
+	"ER": {"Eritrea"},
+	"ES": {"Spain"},
+	"ET": {"Ethiopia"},
+	"FI": {"Finland"},
+	"FJ": {"Fiji"},
+	"FK": {"Falkland Islands", "Falkland Islands (Malvinas)"},
+	"FM": {"Micronesia", "Micronesia, Federated States of"},
+	"FO": {"Faroe Islands"},
+	"FR": {"France"},
+	"GA": {"Gabon"},
+	"GB": {"United Kingdom", "UK", "Great Britain", "Britain", "England", "Scotland", "Wales", "Northern Ireland"},
+	"GD": {"Grenada"},
+	"GE": {"Georgia"},
+	"GF": {"French Guiana"},
+	"GG": {"Guernsey"},
+	"GH": {"Ghana"},
+	"GI": {"Gibraltar"},
+	"GL": {"Greenland"},
+	"GM": {"Gambia", "The Gambia"},
+	"GN": {"Guinea"},
+	"GP": {"Guadeloupe"},
+	"GQ": {"Equatorial Guinea"},
+	"GR": {"Greece"},
+	"GS": {"South Georgia and the South Sandwich Islands"},
+	"GT": {"Guatemala"},
+	"GU": {"Guam"},
+	"GW": {"Guinea-Bissau"},
+	"GY": {"Guyana"},
+	"HK": {"Hong Kong"},
+	"HM": {"Heard Island and McDonald Islands"},
+	"HN": {"Honduras"},
+	"HR": {"Croatia"},
+	"HT": {"Haiti"},
+	"HU": {"Hungary"},
+	"ID": {"Indonesia"},
+	"IE": {"Ireland"},
+	"IL": {"Israel"},
+	"IM": {"Isle of Man"},
+	"IN": {"India"},
+	"IO": {"British Indian Ocean Territory"},
+	"IQ": {"Iraq"},
+	"IR": {"Iran", "Iran, Islamic Republic of"},
+	"IS": {"Iceland"},
+	"IT": {"Italy"},
+	"JE": {"Jersey"},
+	"JM": {"Jamaica"},
+	"JO": {"Jordan"},
+	"JP": {"Japan"},
+	"KE": {"Kenya"},
+	"KG": {"Kyrgyzstan"},
+	"KH": {"Cambodia"},
+	"KI": {"Kiribati"},
+	"KM": {"Comoros"},
+	"KN": {"Saint Kitts and Nevis"},
+	"KP": {"North Korea", "Korea, Democratic People's Republic of"},
+	"KR": {"South Korea", "Korea", "Korea, Republic of", "Republic of Korea"},
+	"KW": {"Kuwait"},
+	"KY": {"Cayman Islands"},
+	"KZ": {"Kazakhstan"},
+	"LA": {"Laos", "Lao People's Democratic Republic"},
+	"LB": {"Lebanon"},
+	"LC": {"Saint Lucia"},
+	"LI": {"Liechtenstein"},
+	"LK": {"Sri Lanka"},
+	"LR": {"Liberia"},
+	"LS": {"Lesotho"},
+	"LT": {"Lithuania"},
+	"LU": {"Luxembourg"},
+	"LV": {"Latvia"},
+	"LY": {"Libya"},
+	"MA": {"Morocco"},
+	"MC": {"Monaco"},
+	"MD": {"Moldova", "Moldova, Republic of"},
+	"ME": {"Montenegro"},
+	"MF": {"Saint Martin", "Saint Martin (French part)"},
+	"MG": {"Madagascar"},
+	"MH": {"Marshall Islands"},
+	"MK": {"North Macedonia", "Macedonia"},
+	"ML": {"Mali"},
+	"MM": {"Myanmar", "Burma"},
+	"MN": {"Mongolia"},
+	"MO": {"Macao", "Macau"},
+	"MP": {"Northern Mariana Islands"},
+	"MQ": {"Martinique"},
+	"MR": {"Mauritania"},
+	"MS": {"Montserrat"},
+	"MT": {"Malta"},
+	"MU": {"Mauritius"},
+	"MV": {"Maldives"},
+	"MW": {"Malawi"},
+	"MX": {"Mexico"},
+	"MY": {"Malaysia"},
+	"MZ": {"Mozambique"},
+	"NA": {"Namibia"},
+	"NC": {"New Caledonia"},
+	"NE": {"Niger"},
+	"NF": {"Norfolk Island"},
+	"NG": {"Nigeria"},
+	"NI": {"Nicaragua"},
+	"NL": {"Netherlands", "The Netherlands", "Holland"},
+	"NO": {"Norway"},
+	"NP": {"Nepal"},
+	"NR": {"Nauru"},
+	"NU": {"Niue"},
+	"NZ": {"New Zealand"},
+	"OM": {"Oman"},
+	"PA": {"Panama"},
+	"PE": {"Peru"},
+	"PF": {"French Polynesia"},
+	"PG": {"Papua New Guinea"},
+	"PH": {"Philippines"},
+	"PK": {"Pakistan"},
+	"PL": {"Poland"},
+	"PM": {"Saint Pierre and Miquelon"},
+	"PN": {"Pitcairn", "Pitcairn Islands"},
+	"PR": {"Puerto Rico"},
+	"PS": {"Palestine", "Palestine, State of", "Palestinian Territory"},
+	"PT": {"Portugal"},
+	"PW": {"Palau"},
+	"PY": {"Paraguay"},
+	"QA": {"Qatar"},
+	"RE": {"Reunion", "Réunion"},
+	"RO": {"Romania"},
+	"RS": {"Serbia"},
+	"RU": {"Russia", "Russian Federation"},
+	"RW": {"Rwanda"},
+	"SA": {"Saudi Arabia"},
+	"SB": {"Solomon Islands"},
+	"SC": {"Seychelles"},
+	"SD": {"Sudan"},
+	"SE": {"Sweden"},
+	"SG": {"Singapore"},
+	"SH": {"Saint Helena", "Saint Helena, Ascension and Tristan da Cunha"},
+	"SI": {"Slovenia"},
+	"SJ": {"Svalbard and Jan Mayen"},
+	"SK": {"Slovakia"},
+	"SL": {"Sierra Leone"},
+	"SM": {"San Marino"},
+	"SN": {"Senegal"},
+	"SO": {"Somalia"},
+	"SR": {"Suriname"},
+	"SS": {"South Sudan"},
+	"ST": {"Sao Tome and Principe", "São Tomé and Príncipe"},
+	"SV": {"El Salvador"},
+	"SX": {"Sint Maarten", "Sint Maarten (Dutch part)"},
+	"SY": {"Syria", "Syrian Arab Republic"},
+	"SZ": {"Eswatini", "Swaziland"},
+	"TC": {"Turks and Caicos Islands"},
+	"TD": {"Chad"},
+	"TF": {"French Southern Territories"},
+	"TG": {"Togo"},
+	"TH": {"Thailand"},
+	"TJ": {"Tajikistan"},
+	"TK": {"Tokelau"},
+	"TL": {"Timor-Leste", "East Timor"},
+	"TM": {"Turkmenistan"},
+	"TN": {"Tunisia"},
+	"TO": {"Tonga"},
+	"TR": {"Turkey", "Türkiye", "Turkiye"},
+	"TT": {"Trinidad and Tobago"},
+	"TV": {"Tuvalu"},
+	"TW": {"Taiwan", "Taiwan, Province of China"},
+	"TZ": {"Tanzania", "Tanzania, United Republic of"},
+	"UA": {"Ukraine"},
+	"UG": {"Uganda"},
+	"UM": {"United States Minor Outlying Islands"},
+	"US": {"United States", "United States of America", "USA"},
+	"UY": {"Uruguay"},
+	"UZ": {"Uzbekistan"},
+	"VA": {"Vatican City", "Holy See", "Holy See (Vatican City State)"},
+	"VC": {"Saint Vincent and the Grenadines"},
+	"VE": {"Venezuela", "Venezuela, Bolivarian Republic of"},
+	"VG": {"British Virgin Islands", "Virgin Islands, British"},
+	"VI": {"U.S. Virgin Islands", "Virgin Islands, U.S."},
+	"VN": {"Vietnam", "Viet Nam"},
+	"VU": {"Vanuatu"},
+	"WF": {"Wallis and Futuna"},
+	"WS": {"Samoa"},
+	"XK": {"Kosovo"},
+	"YE": {"Yemen"},
+	"YT": {"Mayotte"},
+	"ZA": {"South Africa"},
+	"ZM": {"Zambia"},
+	"ZW": {"Zimbabwe"},
+}
+
+// countryCodes maps every lowercased name of countries to its code.
+var countryCodes = func() map[string]string {
+	codes := make(map[string]string)
+	for code, names := range countries {
+		for _, name := range names {
+			codes[strings.ToLower(name)] = code
+		}
+	}
+	return codes
+}()
+
+// LookupCountry resolves a country given by ISO 3166-1 alpha-2 code or by
+// English name, case-insensitively, to its code and name. Underscores stand
+// for spaces (united_states).
+func LookupCountry(country string) (code, name string, ok bool) {
+	country = strings.TrimSpace(strings.ReplaceAll(country, "_", " "))
+	if len(country) == 2 {
+		code = strings.ToUpper(country)
+		if names, ok := countries[code]; ok {
+			return code, names[0], true
+		}
+	}
+	code, ok = countryCodes[strings.ToLower(country)]
+	if !ok {
+		return "", "", false
+	}
+	return code, countries[code][0], true
+}
+
+// InCountry reports whether the result's country is the one given by code or
+// name. Results stored before CountryCode existed are matched by their name.
+func (r IPQualityResult) InCountry(country string) bool {
+	want, _, ok := LookupCountry(country)
+	if !ok {
+		return false
+	}
+	code := r.CountryCode
+	if code == "" {
+		code, _, _ = LookupCountry(r.Country)
+	}
+	return code == want
+}
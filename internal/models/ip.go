@@ -6,6 +6,11 @@ type IPAddress struct {
 }
 
 type IPInput struct {
-	IP   string `json:"ip"`
-	Type string `json:"type"` // "simple" or "proxy"
+	IP     string `json:"ip"`
+	Type   string `json:"type"`             // "simple", "cidr", "range" or "hostname"
+	Line   int    `json:"line,omitempty"`   // 1-based line of the input list
+	Source string `json:"source,omitempty"` // input token an expanded address came from
+
+	// IANA special-purpose category ("public", "private", "documentation", ...), single addresses only
+	Category string `json:"category,omitempty"`
 }
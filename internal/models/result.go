@@ -10,21 +10,42 @@ type WhoisResult struct {
 	ISP         string `json:"isp"`
 	ASN         string `json:"asn"`
 	Timezone    string `json:"timezone"`
-	Status      string `json:"status"` // "success", "failed", "pending"
+	Status      string `json:"status"` // "success", "failed", "pending", "non_routable"
 	Error       string `json:"error,omitempty"`
+	Source      string `json:"source,omitempty"`   // CIDR, range or hostname the IP was expanded from
+	Category    string `json:"category,omitempty"` // IANA special-purpose category, "public" if routable
+	ConnectionClass
+	CacheInfo
+	RetryInfo
 }
 
 type IPQualityResult struct {
 	IP           string `json:"ip"`
 	Port         string `json:"port,omitempty"`
-	Status       string `json:"status"` // "Live", "Dead"
+	Label        string `json:"label,omitempty"`      // node name of imported proxy configs
+	Protocol     string `json:"protocol,omitempty"`   // scheme the proxy answered on, Live only
+	LatencyMS    int64  `json:"latency_ms,omitempty"` // time to the first response through the proxy, Live only
+	Status       string `json:"status"`               // "Live", "Dead"
 	Country      string `json:"country"`
+	CountryCode  string `json:"country_code,omitempty"` // ISO 3166-1 alpha-2
 	City         string `json:"city"`
 	Region       string `json:"region"`
 	VPN          bool   `json:"vpn"`
 	Proxy        bool   `json:"proxy"`
 	ISP          string `json:"isp"`
 	Organization string `json:"organization"`
+	ASN          string `json:"asn,omitempty"`
+	Hostname     string `json:"hostname,omitempty"`
 	FraudScore   string `json:"fraud_score"`
 	Error        string `json:"error,omitempty"`
+	Category     string `json:"category,omitempty"` // IANA special-purpose category of IP
+	Source       string `json:"source,omitempty"`   // reputation provider that answered
+	Direct       bool   `json:"direct,omitempty"`   // looked up from this host instead of through the proxy
+
+	// Hints reported by the provider, used as classifier input
+	ProviderConnectionType string `json:"provider_connection_type,omitempty"`
+	Mobile                 bool   `json:"mobile,omitempty"`
+	ConnectionClass
+	CacheInfo
+	RetryInfo
 }
@@ -0,0 +1,18 @@
+package models
+
+import "fmt"
+
+// RetryInfo records how many attempts the retried steps of a check took.
+type RetryInfo struct {
+	Attempts      int      `json:"attempts,omitempty"`
+	AttemptErrors []string `json:"attempt_errors,omitempty"` // "<step>#<attempt>: <error>"
+}
+
+func (r *RetryInfo) RecordAttempts(step string, attempts int, errs []error) {
+	r.Attempts += attempts
+	for i, err := range errs {
+		if err != nil {
+			r.AttemptErrors = append(r.AttemptErrors, fmt.Sprintf("%s#%d: %s", step, i+1, err))
+		}
+	}
+}
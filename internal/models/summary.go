@@ -0,0 +1,13 @@
+package models
+
+// CheckSummary describes how a check request was served.
+type CheckSummary struct {
+	Total        int   `json:"total"`
+	NetworkCalls int64 `json:"network_calls"` // provider requests sent, retries included
+	CacheHits    int64 `json:"cache_hits"`
+	CallsSaved   int64 `json:"calls_saved"` // lookups coalesced with an identical in-flight call
+	BreakerSkips int64 `json:"breaker_skips"`
+	Concurrency  int   `json:"concurrency,omitempty"` // worker concurrency level when the check finished
+
+	Breakers map[string]string `json:"breakers,omitempty"` // provider -> breaker state at the end of the check
+}
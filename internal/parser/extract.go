@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"net/netip"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	bracketedIPv6Pattern = regexp.MustCompile(`\[([0-9A-Fa-f:.]+)\](?::(\d{1,5}))?`)
+	bareIPv6Pattern      = regexp.MustCompile(`(?:^|[^0-9A-Za-z.])([0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}(?:\.\d{1,3}){0,3})`)
+	ipv4Pattern          = regexp.MustCompile(`(?:^|[^0-9.])((?:\d{1,3}\.){3}\d{1,3})(?::(\d{1,5}))?`)
+)
+
+// ExtractedIP is an address found in free text.
+type ExtractedIP struct {
+	IP        string   `json:"ip"`
+	Count     int      `json:"count"`           // occurrences in the text
+	Ports     []string `json:"ports,omitempty"` // ports seen as ip:port, in order of appearance
+	FirstLine int      `json:"first_line"`
+	Category  string   `json:"category"`
+}
+
+type occurrence struct {
+	pos  int
+	addr netip.Addr
+	port string
+}
+
+// ExtractIPs finds every IPv4 and IPv6 address in arbitrary text such as logs,
+// firewall exports or e-mails: bare, as ip:port, bracketed ([v6]:port) or
+// inside URLs. Addresses are returned in order of first appearance.
+func ExtractIPs(text string) []ExtractedIP {
+	var found []occurrence
+	masked := []byte(text)
+	mask := func(start, end int) {
+		for i := start; i < end; i++ {
+			masked[i] = ' '
+		}
+	}
+
+	// IPv6 first, masking each match so its embedded IPv4 is not found again
+	for _, m := range bracketedIPv6Pattern.FindAllStringSubmatchIndex(text, -1) {
+		addr, err := netip.ParseAddr(text[m[2]:m[3]])
+		if err != nil || !addr.Is6() {
+			continue
+		}
+		found = append(found, occurrence{pos: m[0], addr: addr, port: submatch(text, m, 2)})
+		mask(m[0], m[1])
+	}
+	for _, m := range bareIPv6Pattern.FindAllStringSubmatchIndex(string(masked), -1) {
+		candidate := text[m[2]:m[3]]
+		addr, err := netip.ParseAddr(candidate)
+		// Require two groups so words like "e::" in prose do not count
+		if err != nil || !addr.Is6() || addr.IsUnspecified() || groupCount(candidate) < 2 {
+			continue
+		}
+		found = append(found, occurrence{pos: m[2], addr: addr})
+		mask(m[2], m[3])
+	}
+	for _, m := range ipv4Pattern.FindAllStringSubmatchIndex(string(masked), -1) {
+		end := m[3]
+		if m[4] != -1 {
+			end = m[5]
+		}
+		// Reject longer dotted numbers such as 1.2.3.4.5
+		if end < len(masked) && (isDigit(masked[end]) || masked[end] == '.' && end+1 < len(masked) && isDigit(masked[end+1])) {
+			continue
+		}
+		// Reject product versions such as Chrome/121.0.0.0 in user agents
+		if afterProduct(text, m[2]) {
+			continue
+		}
+		addr, err := netip.ParseAddr(text[m[2]:m[3]])
+		if err != nil {
+			continue
+		}
+		found = append(found, occurrence{pos: m[2], addr: addr, port: submatch(text, m, 2)})
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].pos < found[j].pos })
+
+	var results []ExtractedIP
+	index := make(map[netip.Addr]int)
+	line, lineStart := 1, 0
+	for _, o := range found {
+		line += strings.Count(text[lineStart:o.pos], "\n")
+		lineStart = o.pos
+
+		addr := o.addr.Unmap()
+		i, ok := index[addr]
+		if !ok {
+			i = len(results)
+			index[addr] = i
+			results = append(results, ExtractedIP{IP: addr.String(), FirstLine: line, Category: ClassifyAddr(addr).Category})
+		}
+		results[i].Count++
+		if o.port != "" && !slices.Contains(results[i].Ports, o.port) {
+			results[i].Ports = append(results[i].Ports, o.port)
+		}
+	}
+	return results
+}
+
+// submatch returns the valid port captured by group n of m, or "".
+func submatch(text string, m []int, n int) string {
+	if m[2*n] == -1 {
+		return ""
+	}
+	port := text[m[2*n]:m[2*n+1]]
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return ""
+	}
+	return port
+}
+
+func groupCount(s string) int {
+	n := 0
+	for _, g := range strings.Split(s, ":") {
+		if g != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// afterProduct reports whether pos directly follows a product token and a
+// slash, as in "Chrome/". URLs (http://1.2.3.4) and paths (GET /1.2.3.4) do
+// not count: their slash follows another slash, a colon or a space.
+func afterProduct(text string, pos int) bool {
+	if pos == 0 || text[pos-1] != '/' {
+		return false
+	}
+	start := pos - 1
+	for start > 0 && isProductChar(text[start-1]) {
+		start--
+	}
+	if start == pos-1 || !isLetter(text[start]) {
+		return false
+	}
+	return start == 0 || strings.IndexByte(" \t(;\"'", text[start-1]) >= 0
+}
+
+func isProductChar(c byte) bool {
+	return isLetter(c) || isDigit(c) || c == '_' || c == '-' || c == '.'
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,606 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// File formats accepted by ParseFile
+const (
+	FormatText = "txt"
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// formatProxyConfig asks parseProxyConfigFile to detect the config flavour.
+const formatProxyConfig = "config"
+
+// sniffSize is how much of a file is looked at to guess its format and delimiter.
+const sniffSize = 64 << 10
+
+// Proxy fields a CSV column or JSON key can be mapped to
+const (
+	colHost = iota
+	colPort
+	colUser
+	colPass
+	colProtocol
+	numColumns
+)
+
+var columnNames = [numColumns]string{"host", "port", "user", "pass", "protocol"}
+
+// columnAliases are the header names recognised for each field, normalized
+// by normalizeHeader.
+var columnAliases = [numColumns][]string{
+	{"host", "ip", "ipaddress", "address", "addr", "hostname", "server", "proxy", "proxyip", "proxyhost", "proxyaddress"},
+	{"port", "proxyport"},
+	{"user", "username", "login", "proxyuser", "proxyusername", "proxylogin"},
+	{"pass", "password", "pwd", "proxypass", "proxypassword"},
+	{"protocol", "scheme", "type", "proxytype", "proxyprotocol"},
+}
+
+// Columns maps proxy fields to CSV columns or JSON object keys. Each value is
+// a header name (case-insensitive) or, for CSV, a 1-based column number.
+type Columns struct {
+	Host     string `json:"host,omitempty" yaml:"host"`
+	Port     string `json:"port,omitempty" yaml:"port"`
+	User     string `json:"user,omitempty" yaml:"user"`
+	Pass     string `json:"pass,omitempty" yaml:"pass"`
+	Protocol string `json:"protocol,omitempty" yaml:"protocol"`
+}
+
+func (c Columns) values() [numColumns]string {
+	return [numColumns]string{c.Host, c.Port, c.User, c.Pass, c.Protocol}
+}
+
+// FileOptions controls ParseFile.
+type FileOptions struct {
+	ParseOptions
+	Name      string // file name, used to guess the format
+	Format    string // txt, csv (or tsv), json, clash, sing-box or v2ray; guessed when empty
+	Delimiter string // CSV delimiter, "tab" or "\t" for tabs; detected when empty
+	Header    string // "yes" or "no"; detected when empty
+	Columns   Columns
+}
+
+// FileInfo describes how ParseFile read a file.
+type FileInfo struct {
+	Format    string         `json:"format"`
+	Delimiter string         `json:"delimiter,omitempty"`
+	Header    []string       `json:"header,omitempty"`
+	Columns   map[string]int `json:"columns,omitempty"` // field -> 1-based column
+}
+
+// ParseFile streams an uploaded list into a report. Text files hold one entry
+// per line, as for Parse. CSV rows are assembled from their host, port, user,
+// pass and protocol columns; a row with a single column is read as a line.
+// JSON files must be an array of entry strings or of objects keyed like CSV
+// columns, and Line is then the 1-based array index. Clash, sing-box and V2Ray
+// configs are read with ParseProxyConfig. UTF-8 and UTF-16 byte order marks,
+// as written by spreadsheet exports, are honoured.
+//
+// An error is only returned when the file as a whole cannot be read; bad rows
+// end up in Rejected.
+func ParseFile(r io.Reader, opts FileOptions) (ParseReport, error) {
+	br := bufio.NewReaderSize(decodeText(r), sniffSize)
+	format := strings.ToLower(opts.Format)
+	if format == "tsv" {
+		format = FormatCSV
+		opts.Delimiter = cmp.Or(opts.Delimiter, "tab")
+	}
+	if format == "" {
+		format = guessFormat(opts.Name, br)
+	}
+	if format == FormatJSON {
+		// A JSON object rather than an array can only be a proxy client config
+		if sample, _ := br.Peek(sniffSize); bytes.HasPrefix(bytes.TrimSpace(sample), []byte("{")) {
+			format = formatProxyConfig
+		}
+	}
+	info := &FileInfo{Format: format}
+
+	if opts.Extract {
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return ParseReport{}, err
+		}
+		report := Parse(string(data), opts.ParseOptions)
+		report.File = info
+		return report, nil
+	}
+
+	b := newReportBuilder(opts.ParseOptions)
+	var err error
+	switch {
+	case format == formatProxyConfig || IsProxyConfigFormat(format):
+		info.Format, err = parseProxyConfigFile(br, format, b)
+	case format == FormatText:
+		err = parseTextFile(br, b)
+	case format == FormatCSV:
+		err = parseCSVFile(br, opts, b, info)
+	case format == FormatJSON:
+		err = parseJSONFile(br, opts.Columns, b)
+	default:
+		return ParseReport{}, fmt.Errorf("unsupported format %q: use txt, csv, json, clash, sing-box or v2ray", opts.Format)
+	}
+	if err != nil {
+		return ParseReport{}, err
+	}
+	report := b.finish()
+	report.File = info
+	return report, nil
+}
+
+// guessFormat picks the format from the file extension, else from the content.
+func guessFormat(name string, br *bufio.Reader) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv", ".tsv":
+		return FormatCSV
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatClash
+	case ".txt", ".lst", ".list":
+		return FormatText
+	}
+	sample, _ := br.Peek(sniffSize)
+	trimmed := bytes.TrimSpace(sample)
+	if bytes.HasPrefix(trimmed, []byte("[")) || bytes.HasPrefix(trimmed, []byte("{")) {
+		return FormatJSON
+	}
+	if bytes.HasPrefix(trimmed, []byte("proxies:")) || bytes.Contains(sample, []byte("\nproxies:")) {
+		return FormatClash
+	}
+	if lines := sampleLines(sample); len(lines) > 1 && detectDelimiter(lines) != 0 {
+		return FormatCSV
+	}
+	return FormatText
+}
+
+// parseProxyConfigFile adds the proxies of a Clash, sing-box or V2Ray config
+// and returns the detected format. Configs are small, so they are read whole.
+func parseProxyConfigFile(br *bufio.Reader, format string, b *reportBuilder) (string, error) {
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return "", err
+	}
+	if format == formatProxyConfig {
+		format = ""
+	}
+	format, proxies, errs, err := ParseProxyConfig(data, format)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range errs {
+		b.reject(e.Line, e.Input, e.Reason)
+	}
+	for _, p := range proxies {
+		b.addProxy(p, nodeName(p.Label, p.Scheme))
+	}
+	return format, nil
+}
+
+func parseTextFile(br *bufio.Reader, b *reportBuilder) error {
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		b.add(line, scanner.Text(), scanner.Text())
+	}
+	return scanner.Err()
+}
+
+func parseCSVFile(br *bufio.Reader, opts FileOptions, b *reportBuilder, info *FileInfo) error {
+	delim, err := csvDelimiter(opts.Delimiter, br)
+	if err != nil {
+		return err
+	}
+	info.Delimiter = string(delim)
+
+	cr := csv.NewReader(br)
+	cr.Comma = delim
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.TrimLeadingSpace = true
+
+	var cols [numColumns]int
+	first := true
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			b.reject(parseErr.Line, "", parseErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		if !slices.ContainsFunc(record, func(f string) bool { return strings.TrimSpace(f) != "" }) {
+			continue
+		}
+
+		if first {
+			first = false
+			var header []string
+			switch strings.ToLower(opts.Header) {
+			case "yes", "true", "1":
+				header = record
+			case "no", "false", "0":
+			case "", "auto":
+				if isHeaderRow(record) {
+					header = record
+				}
+			default:
+				return fmt.Errorf("invalid header option %q: use yes, no or auto", opts.Header)
+			}
+			if cols, err = resolveColumns(opts.Columns, header, len(record)); err != nil {
+				return err
+			}
+			info.Header = header
+			info.Columns = make(map[string]int)
+			for f, c := range cols {
+				if c >= 0 {
+					info.Columns[columnNames[f]] = c + 1
+				}
+			}
+			if header != nil {
+				continue
+			}
+		}
+
+		raw := strings.Join(record, string(delim))
+		var values [numColumns]string
+		for f, c := range cols {
+			if c >= 0 && c < len(record) {
+				values[f] = record[c]
+			}
+		}
+		if cols[colHost] < 0 {
+			// Single-column file without a mapping: the cell is the whole entry
+			values[colHost] = record[0]
+		}
+		token, err := rowToken(values)
+		if err != nil {
+			b.reject(line, raw, err.Error())
+			continue
+		}
+		b.add(line, raw, token)
+	}
+}
+
+// csvDelimiter returns the configured delimiter, or detects it from the start of the file.
+func csvDelimiter(option string, br *bufio.Reader) (rune, error) {
+	switch option {
+	case "":
+		sample, _ := br.Peek(sniffSize)
+		if d := detectDelimiter(sampleLines(sample)); d != 0 {
+			return d, nil
+		}
+		return ',', nil
+	case "tab", `\t`:
+		return '\t', nil
+	}
+	d, size := utf8.DecodeRuneInString(option)
+	if size != len(option) || d == '"' || d == '\r' || d == '\n' || d == utf8.RuneError {
+		return 0, fmt.Errorf("invalid delimiter %q", option)
+	}
+	return d, nil
+}
+
+// sampleLines returns up to 20 non-empty lines from the start of sample,
+// dropping the last one when it may have been cut off.
+func sampleLines(sample []byte) []string {
+	if len(sample) == sniffSize {
+		if i := bytes.LastIndexByte(sample, '\n'); i >= 0 {
+			sample = sample[:i]
+		}
+	}
+	var lines []string
+	for _, line := range strings.Split(string(sample), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+			if len(lines) == 20 {
+				break
+			}
+		}
+	}
+	return lines
+}
+
+// detectDelimiter returns the candidate that splits every line into the same
+// number of fields, preferring the one that yields the most fields.
+func detectDelimiter(lines []string) rune {
+	var best rune
+	bestCount := 0
+	for _, d := range []rune{',', ';', '\t', '|'} {
+		count := -1
+		for _, line := range lines {
+			n := countUnquoted(line, d)
+			if n == 0 || count >= 0 && n != count {
+				count = -1
+				break
+			}
+			count = n
+		}
+		if count > bestCount {
+			best, bestCount = d, count
+		}
+	}
+	return best
+}
+
+func countUnquoted(line string, d rune) int {
+	n, quoted := 0, false
+	for _, c := range line {
+		switch c {
+		case '"':
+			quoted = !quoted
+		case d:
+			if !quoted {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// isHeaderRow reports whether record names columns rather than holding data:
+// some cell is a known column name and none is an address or a number.
+func isHeaderRow(record []string) bool {
+	named := false
+	for _, cell := range record {
+		cell = strings.TrimSpace(cell)
+		if _, err := strconv.Atoi(cell); err == nil {
+			return false
+		}
+		if _, err := netip.ParseAddr(cell); err == nil {
+			return false
+		}
+		if columnField(normalizeHeader(cell)) >= 0 {
+			named = true
+		}
+	}
+	return named
+}
+
+// resolveColumns finds the 0-based column of every field, -1 when unused.
+// Explicit mappings win; other fields are found by header name. Without a
+// header and a mapping, multi-column rows are read as host, port, user, pass,
+// protocol, and single-column rows are left to the caller.
+func resolveColumns(mapping Columns, header []string, width int) ([numColumns]int, error) {
+	var cols [numColumns]int
+	for f := range cols {
+		cols[f] = -1
+	}
+	explicit := false
+	for f, name := range mapping.values() {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		explicit = true
+		if n, err := strconv.Atoi(name); err == nil {
+			if n < 1 {
+				return cols, fmt.Errorf("invalid %s column %d", columnNames[f], n)
+			}
+			cols[f] = n - 1
+			continue
+		}
+		i := slices.IndexFunc(header, func(h string) bool { return normalizeHeader(h) == normalizeHeader(name) })
+		if i < 0 {
+			return cols, fmt.Errorf("%s column %q not found in the header", columnNames[f], name)
+		}
+		cols[f] = i
+	}
+
+	switch {
+	case header != nil:
+		for i, h := range header {
+			if f := columnField(normalizeHeader(h)); f >= 0 && cols[f] < 0 && !slices.Contains(cols[:], i) {
+				cols[f] = i
+			}
+		}
+		if cols[colHost] < 0 {
+			return cols, errors.New("no host column in the header; map one with the host column option")
+		}
+	case !explicit && width > 1:
+		for f := range min(width, int(numColumns)) {
+			cols[f] = f
+		}
+	case explicit && cols[colHost] < 0:
+		return cols, errors.New("the host column must be mapped")
+	}
+	return cols, nil
+}
+
+func parseJSONFile(br *bufio.Reader, mapping Columns, b *reportBuilder) error {
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+	if tok, err := dec.Token(); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	} else if tok != json.Delim('[') {
+		return errors.New("JSON input must be an array")
+	}
+
+	for i := 1; dec.More(); i++ {
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			return fmt.Errorf("invalid JSON at element %d: %w", i, err)
+		}
+		switch v := v.(type) {
+		case string:
+			b.add(i, v, v)
+		case map[string]any:
+			raw, _ := json.Marshal(v)
+			token, err := rowToken(objectValues(v, mapping))
+			if err != nil {
+				b.reject(i, string(raw), err.Error())
+				continue
+			}
+			b.add(i, string(raw), token)
+		default:
+			b.reject(i, fmt.Sprint(v), "expected a string or an object")
+		}
+	}
+	return nil
+}
+
+// objectValues picks the proxy fields out of a JSON object by mapped key or known name.
+func objectValues(obj map[string]any, mapping Columns) [numColumns]string {
+	keys := make(map[string]any, len(obj))
+	for k, v := range obj {
+		keys[normalizeHeader(k)] = v
+	}
+	var values [numColumns]string
+	for f, name := range mapping.values() {
+		candidates := columnAliases[f]
+		if name != "" {
+			candidates = []string{normalizeHeader(name)}
+		}
+		for _, key := range candidates {
+			if v, ok := keys[key]; ok && v != nil {
+				values[f] = fmt.Sprint(v)
+				break
+			}
+		}
+	}
+	return values
+}
+
+// rowToken assembles the fields of one row into an entry ParseProxy or
+// ParseIPToken understands. A host without a port may itself be a full entry.
+func rowToken(values [numColumns]string) (string, error) {
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	token, port, user, pass := values[colHost], values[colPort], values[colUser], values[colPass]
+	if token == "" {
+		return "", errors.New("empty host")
+	}
+
+	scheme := strings.ToLower(strings.TrimSuffix(values[colProtocol], "://"))
+	if scheme == "socks" {
+		scheme = "socks5"
+	}
+	if scheme != "" && !slices.Contains(ProxySchemes, scheme) {
+		return "", fmt.Errorf("unsupported protocol %q", values[colProtocol])
+	}
+	if strings.Contains(token, "://") {
+		scheme = ""
+	}
+
+	if port != "" {
+		if addr, err := netip.ParseAddr(token); err == nil && addr.Is6() {
+			token = "[" + token + "]"
+		}
+		token += ":" + port
+	}
+	if user != "" || pass != "" {
+		if scheme != "" {
+			user, pass = escapeCredential(user), escapeCredential(pass)
+		}
+		token = user + ":" + pass + "@" + token
+	}
+	if scheme != "" {
+		token = scheme + "://" + token
+	}
+	return token, nil
+}
+
+// escapeCredential percent-encodes s for the user info of a proxy URI.
+func escapeCredential(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func normalizeHeader(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '_', '-', '.':
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(s)))
+}
+
+func columnField(normalized string) int {
+	for f, aliases := range columnAliases {
+		if slices.Contains(aliases, normalized) {
+			return f
+		}
+	}
+	return -1
+}
+
+// decodeText strips a UTF-8 byte order mark and transcodes UTF-16 input,
+// which spreadsheet "Unicode text" exports produce, to UTF-8.
+func decodeText(r io.Reader) io.Reader {
+	br := bufio.NewReader(r)
+	bom, _ := br.Peek(3)
+	switch {
+	case bytes.HasPrefix(bom, []byte{0xEF, 0xBB, 0xBF}):
+		br.Discard(3)
+	case bytes.HasPrefix(bom, []byte{0xFF, 0xFE}):
+		br.Discard(2)
+		return &utf16Reader{r: br, order: binary.LittleEndian}
+	case bytes.HasPrefix(bom, []byte{0xFE, 0xFF}):
+		br.Discard(2)
+		return &utf16Reader{r: br, order: binary.BigEndian}
+	}
+	return br
+}
+
+type utf16Reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n+utf8.UTFMax <= len(p) {
+		c, err := u.next()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		n += utf8.EncodeRune(p[n:], c)
+	}
+	if n == 0 {
+		return 0, io.ErrShortBuffer
+	}
+	return n, nil
+}
+
+func (u *utf16Reader) next() (rune, error) {
+	var unit [2]byte
+	if _, err := io.ReadFull(u.r, unit[:]); err != nil {
+		return 0, err
+	}
+	c := rune(u.order.Uint16(unit[:]))
+	if !utf16.IsSurrogate(c) {
+		return c, nil
+	}
+	if _, err := io.ReadFull(u.r, unit[:]); err != nil {
+		return utf8.RuneError, nil
+	}
+	return utf16.DecodeRune(c, rune(u.order.Uint16(unit[:]))), nil
+}
@@ -1,30 +1,280 @@
 package parser
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"ip-proxy-checker/internal/models"
 	"net"
+	"net/netip"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
+// IP input token types
+const (
+	IPTypeSimple   = "simple"
+	IPTypeCIDR     = "cidr"
+	IPTypeRange    = "range"
+	IPTypeHostname = "hostname"
+)
+
+// errNotIPToken is returned for input that does not look like any IP token at all.
+var errNotIPToken = errors.New("not an IP, CIDR, range or hostname")
+
+// DefaultMaxExpansion bounds how many addresses one list may expand to.
+const DefaultMaxExpansion = 4096
+
+// ParseIPList returns the IP tokens of a list: literal IPs, CIDRs, dash
+// ranges and hostnames. Nothing is expanded or resolved yet.
 func ParseIPList(input string) []models.IPInput {
 	lines := strings.Split(input, "\n")
 	var results []models.IPInput
-	for _, line := range lines {
+	for i, line := range lines {
 		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
 		}
 
-		// If it contains a colon, it might be a proxy or IPv6
-		// But simple format is just IP
-		if net.ParseIP(line) != nil {
+		if ip, err := ParseIPToken(line); err == nil {
+			ip.Line = i + 1
+			results = append(results, ip)
+		}
+	}
+	return results
+}
+
+// ParseIPToken classifies and validates one IP token:
+//
+//	1.2.3.4  2001:db8::1                   simple
+//	10.0.0.0/24  2001:db8::/120            cidr
+//	10.0.0.1-10.0.0.50  10.0.0.1-50        range
+//	example.com                            hostname
+//
+// Hostnames must contain a dot so that stray words are not looked up.
+func ParseIPToken(token string) (models.IPInput, error) {
+	token = strings.TrimSpace(token)
+	if addr, err := netip.ParseAddr(token); err == nil {
+		return models.IPInput{IP: addr.Unmap().String(), Type: IPTypeSimple, Category: ClassifyAddr(addr).Category}, nil
+	}
+	if strings.Contains(token, "/") {
+		prefix, err := netip.ParsePrefix(token)
+		if err != nil {
+			return models.IPInput{}, fmt.Errorf("invalid CIDR %q", token)
+		}
+		return models.IPInput{IP: prefix.Masked().String(), Type: IPTypeCIDR}, nil
+	}
+	if first, _, ok := strings.Cut(token, "-"); ok {
+		if _, err := netip.ParseAddr(strings.TrimSpace(first)); err == nil {
+			start, end, err := parseRange(token)
+			if err != nil {
+				return models.IPInput{}, err
+			}
+			return models.IPInput{IP: start.String() + "-" + end.String(), Type: IPTypeRange}, nil
+		}
+	}
+	if strings.Contains(token, ".") && validHostname(token) {
+		return models.IPInput{IP: strings.ToLower(strings.TrimSuffix(token, ".")), Type: IPTypeHostname}, nil
+	}
+	return models.IPInput{}, errNotIPToken
+}
+
+// parseRange parses "start-end", where end may be the last IPv4 octet only.
+func parseRange(token string) (netip.Addr, netip.Addr, error) {
+	first, last, _ := strings.Cut(token, "-")
+	start, err := netip.ParseAddr(strings.TrimSpace(first))
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid range start %q", first)
+	}
+	start = start.Unmap()
+	last = strings.TrimSpace(last)
+
+	end, err := netip.ParseAddr(last)
+	if err != nil {
+		octet, convErr := strconv.Atoi(last)
+		if convErr != nil || !start.Is4() || octet < 0 || octet > 255 {
+			return netip.Addr{}, netip.Addr{}, fmt.Errorf("invalid range end %q", last)
+		}
+		b := start.As4()
+		b[3] = byte(octet)
+		end = netip.AddrFrom4(b)
+	}
+	end = end.Unmap()
+
+	if start.Is4() != end.Is4() {
+		return netip.Addr{}, netip.Addr{}, errors.New("range mixes IPv4 and IPv6")
+	}
+	if end.Less(start) {
+		return netip.Addr{}, netip.Addr{}, errors.New("range end is before its start")
+	}
+	return start, end, nil
+}
+
+// DefaultResolveConcurrency is the number of hostnames ExpandIPs resolves at
+// once when ExpandOptions sets none.
+const DefaultResolveConcurrency = 8
+
+// ExpandOptions controls ExpandIPs.
+type ExpandOptions struct {
+	MaxExpansion       int           // total addresses allowed, <= 0 means DefaultMaxExpansion
+	ResolveTimeout     time.Duration // budget of each hostname, <= 0 means none
+	ResolveConcurrency int           // hostnames resolved at once, <= 0 means DefaultResolveConcurrency
+
+	// LookupNetIP resolves a hostname, nil uses net.DefaultResolver
+	LookupNetIP func(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// ExpandIPs turns IP tokens into single addresses. CIDRs and ranges are
+// expanded, hostnames resolved to all their A and AAAA records. Every result
+// keeps the token it came from in Source. Only expanded addresses count
+// against the cap, literal IPs never do. A token that is invalid, fails to
+// resolve or would push the expansion over the cap is reported instead; Line is
+// its 1-based position in tokens. Hostnames are resolved concurrently, each
+// within its own ResolveTimeout.
+func ExpandIPs(ctx context.Context, tokens []string, opts ExpandOptions) ([]models.IPInput, []LineError) {
+	limit := opts.MaxExpansion
+	if limit <= 0 {
+		limit = DefaultMaxExpansion
+	}
+
+	parsed := make([]models.IPInput, len(tokens))
+	parseErrs := make([]error, len(tokens))
+	for i, raw := range tokens {
+		parsed[i], parseErrs[i] = ParseIPToken(strings.TrimSpace(raw))
+	}
+	lookups := resolveHostnames(ctx, parsed, parseErrs, opts)
+
+	var results []models.IPInput
+	var errs []LineError
+	expanded := 0
+	for i, raw := range tokens {
+		raw = strings.TrimSpace(raw)
+		reject := func(err error) {
+			errs = append(errs, LineError{Line: i + 1, Input: raw, Reason: err.Error()})
+		}
+
+		token, err := parsed[i], parseErrs[i]
+		if err != nil {
+			reject(err)
+			continue
+		}
+
+		remaining := limit - expanded
+		var addrs []netip.Addr
+		fits := true
+		switch token.Type {
+		case IPTypeSimple:
+			addrs = []netip.Addr{netip.MustParseAddr(token.IP)}
+		case IPTypeCIDR:
+			addrs, fits = expandPrefix(netip.MustParsePrefix(token.IP), remaining)
+		case IPTypeRange:
+			start, end, _ := parseRange(token.IP)
+			addrs, fits = expandRange(start, end, remaining)
+		case IPTypeHostname:
+			addrs, err = lookups[i].addrs, lookups[i].err
+		}
+		if token.Type != IPTypeSimple && (!fits || len(addrs) > remaining) {
+			err = fmt.Errorf("would exceed the expansion cap of %d addresses", limit)
+		}
+		if err != nil {
+			reject(err)
+			continue
+		}
+
+		if token.Type != IPTypeSimple {
+			expanded += len(addrs)
+		}
+		seen := make(map[netip.Addr]bool, len(addrs))
+		for _, addr := range addrs {
+			addr = addr.Unmap()
+			if seen[addr] {
+				continue
+			}
+			seen[addr] = true
 			results = append(results, models.IPInput{
-				IP:   line,
-				Type: "simple",
+				IP:       addr.String(),
+				Type:     token.Type,
+				Line:     i + 1,
+				Source:   raw,
+				Category: ClassifyAddr(addr).Category,
 			})
 		}
 	}
-	return results
+	return results, errs
+}
+
+type hostLookup struct {
+	addrs []netip.Addr
+	err   error
+}
+
+// resolveHostnames looks up the hostname tokens, at most
+// opts.ResolveConcurrency at a time. The result is indexed like tokens.
+func resolveHostnames(ctx context.Context, tokens []models.IPInput, errs []error, opts ExpandOptions) []hostLookup {
+	lookup := opts.LookupNetIP
+	if lookup == nil {
+		lookup = net.DefaultResolver.LookupNetIP
+	}
+	concurrency := opts.ResolveConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultResolveConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+
+	lookups := make([]hostLookup, len(tokens))
+	var wg sync.WaitGroup
+	for i, token := range tokens {
+		if errs[i] != nil || token.Type != IPTypeHostname {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			lookupCtx := ctx
+			if opts.ResolveTimeout > 0 {
+				var cancel context.CancelFunc
+				lookupCtx, cancel = context.WithTimeout(ctx, opts.ResolveTimeout)
+				defer cancel()
+			}
+			addrs, err := lookup(lookupCtx, "ip", token.IP)
+			if err != nil {
+				err = fmt.Errorf("resolve %s: %w", token.IP, err)
+			}
+			lookups[i] = hostLookup{addrs, err}
+		}()
+	}
+	wg.Wait()
+	return lookups
+}
+
+// expandPrefix lists the addresses of prefix, or reports false if there are more than max.
+func expandPrefix(prefix netip.Prefix, max int) ([]netip.Addr, bool) {
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits >= 31 || 1<<hostBits > max {
+		return nil, false
+	}
+	addrs := make([]netip.Addr, 0, 1<<hostBits)
+	for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+		addrs = append(addrs, addr)
+	}
+	return addrs, true
+}
+
+// expandRange lists the addresses from start to end, or reports false if there are more than max.
+func expandRange(start, end netip.Addr, max int) ([]netip.Addr, bool) {
+	var addrs []netip.Addr
+	for addr := start; addr.IsValid() && !end.Less(addr); addr = addr.Next() {
+		if len(addrs) == max {
+			return nil, false
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, true
 }
 
 func GetIPType(ip string) string {
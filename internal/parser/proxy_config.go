@@ -0,0 +1,329 @@
+package parser
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ip-proxy-checker/internal/models"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Proxy client config formats
+const (
+	FormatClash   = "clash"
+	FormatSingBox = "sing-box"
+	FormatV2Ray   = "v2ray" // also Xray
+)
+
+// IsProxyConfigFormat reports whether format names a proxy client config.
+func IsProxyConfigFormat(format string) bool {
+	return format == FormatClash || format == FormatSingBox || format == FormatV2Ray
+}
+
+// ParseProxyConfig extracts the proxies of a Clash YAML, sing-box JSON or
+// V2Ray / Xray JSON config, detecting the format when it is empty. Every HTTP
+// and SOCKS node becomes a ProxyInput labelled with the node name; HTTP over
+// TLS becomes https. Nodes of other protocols cannot be checked and are
+// reported as LineErrors, while routing outbounds (direct, block, selectors)
+// are skipped. Line is the YAML line for Clash and the 1-based outbound index
+// for JSON configs.
+func ParseProxyConfig(data []byte, format string) (string, []models.ProxyInput, []LineError, error) {
+	if format == "" {
+		var err error
+		if format, err = detectProxyConfig(data); err != nil {
+			return "", nil, nil, err
+		}
+	}
+	var proxies []models.ProxyInput
+	var errs []LineError
+	var err error
+	switch format {
+	case FormatClash:
+		proxies, errs, err = parseClash(data)
+	case FormatSingBox:
+		proxies, errs, err = parseSingBox(data)
+	case FormatV2Ray:
+		proxies, errs, err = parseV2Ray(data)
+	default:
+		return "", nil, nil, fmt.Errorf("unsupported config format %q", format)
+	}
+	return format, proxies, errs, err
+}
+
+func detectProxyConfig(data []byte) (string, error) {
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("{")) {
+		var cfg struct {
+			Outbounds []map[string]json.RawMessage `json:"outbounds"`
+		}
+		if err := json.Unmarshal(trimmed, &cfg); err != nil {
+			return "", fmt.Errorf("invalid JSON: %w", err)
+		}
+		for _, o := range cfg.Outbounds {
+			if _, ok := o["protocol"]; ok {
+				return FormatV2Ray, nil
+			}
+			if _, ok := o["type"]; ok {
+				return FormatSingBox, nil
+			}
+		}
+		return "", errors.New("no outbounds in the JSON config")
+	}
+	var cfg struct {
+		Proxies []any `yaml:"proxies"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil || cfg.Proxies == nil {
+		return "", errors.New("not a Clash, sing-box or V2Ray config")
+	}
+	return FormatClash, nil
+}
+
+type clashProxy struct {
+	Name           string `yaml:"name"`
+	Type           string `yaml:"type"`
+	Server         string `yaml:"server"`
+	Port           string `yaml:"port"`
+	Username       string `yaml:"username"`
+	Password       string `yaml:"password"`
+	TLS            bool   `yaml:"tls"`
+	SkipCertVerify bool   `yaml:"skip-cert-verify"`
+	SNI            string `yaml:"sni"`
+	ServerName     string `yaml:"servername"`
+}
+
+func parseClash(data []byte) ([]models.ProxyInput, []LineError, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, nil, fmt.Errorf("invalid YAML: %w", err)
+	}
+	if len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, errors.New("Clash config must be a mapping")
+	}
+	var list *yaml.Node
+	doc := root.Content[0]
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value == "proxies" {
+			list = doc.Content[i+1]
+		}
+	}
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return nil, nil, errors.New("Clash config has no proxies list")
+	}
+
+	var proxies []models.ProxyInput
+	var errs []LineError
+	for _, node := range list.Content {
+		var c clashProxy
+		if err := node.Decode(&c); err != nil {
+			errs = append(errs, LineError{Line: node.Line, Reason: err.Error()})
+			continue
+		}
+		p := models.ProxyInput{Username: c.Username, Password: c.Password, Label: c.Name, Line: node.Line}
+		if c.TLS {
+			p.TLS = &models.ProxyTLS{ServerName: cmp.Or(c.SNI, c.ServerName), Insecure: c.SkipCertVerify}
+		}
+		var err error
+		switch c.Type {
+		case "http":
+			p.Scheme = "http"
+			if c.TLS {
+				p.Scheme = "https"
+			}
+		case "socks5":
+			p.Scheme = "socks5"
+		default:
+			err = fmt.Errorf("unsupported proxy type %q", c.Type)
+		}
+		if err == nil {
+			p.Host, p.Port, err = serverPort(c.Server, c.Port)
+		}
+		if err != nil {
+			errs = append(errs, LineError{Line: node.Line, Input: nodeName(c.Name, c.Type), Reason: err.Error()})
+			continue
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies, errs, nil
+}
+
+// sing-box outbounds that route traffic rather than proxy it
+var singBoxRouting = []string{"direct", "block", "dns", "selector", "urltest"}
+
+type singBoxOutbound struct {
+	Type       string `json:"type"`
+	Tag        string `json:"tag"`
+	Server     string `json:"server"`
+	ServerPort int    `json:"server_port"`
+	Version    string `json:"version"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	TLS        *struct {
+		Enabled    bool   `json:"enabled"`
+		ServerName string `json:"server_name"`
+		Insecure   bool   `json:"insecure"`
+	} `json:"tls"`
+}
+
+func parseSingBox(data []byte) ([]models.ProxyInput, []LineError, error) {
+	var cfg struct {
+		Outbounds []json.RawMessage `json:"outbounds"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var proxies []models.ProxyInput
+	var errs []LineError
+	for i, raw := range cfg.Outbounds {
+		var o singBoxOutbound
+		if err := json.Unmarshal(raw, &o); err != nil {
+			errs = append(errs, LineError{Line: i + 1, Input: string(raw), Reason: err.Error()})
+			continue
+		}
+		if slices.Contains(singBoxRouting, o.Type) {
+			continue
+		}
+		p := models.ProxyInput{Username: o.Username, Password: o.Password, Label: o.Tag, Line: i + 1}
+		var err error
+		switch o.Type {
+		case "http":
+			p.Scheme = "http"
+			if o.TLS != nil && o.TLS.Enabled {
+				p.Scheme = "https"
+				p.TLS = &models.ProxyTLS{ServerName: o.TLS.ServerName, Insecure: o.TLS.Insecure}
+			}
+		case "socks":
+			switch o.Version {
+			case "", "5":
+				p.Scheme = "socks5"
+			case "4", "4a":
+				p.Scheme = "socks" + o.Version
+			default:
+				err = fmt.Errorf("unsupported SOCKS version %q", o.Version)
+			}
+		default:
+			err = fmt.Errorf("unsupported outbound type %q", o.Type)
+		}
+		if err == nil {
+			p.Host, p.Port, err = serverPort(o.Server, strconv.Itoa(o.ServerPort))
+		}
+		if err != nil {
+			errs = append(errs, LineError{Line: i + 1, Input: nodeName(o.Tag, o.Type), Reason: err.Error()})
+			continue
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies, errs, nil
+}
+
+// V2Ray outbounds that route traffic rather than proxy it
+var v2rayRouting = []string{"freedom", "blackhole", "dns", "loopback"}
+
+type v2rayOutbound struct {
+	Protocol string `json:"protocol"`
+	Tag      string `json:"tag"`
+	Settings struct {
+		Servers []struct {
+			Address string `json:"address"`
+			Port    int    `json:"port"`
+			Users   []struct {
+				User string `json:"user"`
+				Pass string `json:"pass"`
+			} `json:"users"`
+		} `json:"servers"`
+	} `json:"settings"`
+	StreamSettings struct {
+		Security    string `json:"security"`
+		TLSSettings struct {
+			ServerName    string `json:"serverName"`
+			AllowInsecure bool   `json:"allowInsecure"`
+		} `json:"tlsSettings"`
+	} `json:"streamSettings"`
+}
+
+// parseV2Ray returns one proxy per server and user of every HTTP and SOCKS outbound.
+func parseV2Ray(data []byte) ([]models.ProxyInput, []LineError, error) {
+	var cfg struct {
+		Outbounds []json.RawMessage `json:"outbounds"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var proxies []models.ProxyInput
+	var errs []LineError
+	for i, raw := range cfg.Outbounds {
+		var o v2rayOutbound
+		if err := json.Unmarshal(raw, &o); err != nil {
+			errs = append(errs, LineError{Line: i + 1, Input: string(raw), Reason: err.Error()})
+			continue
+		}
+		if slices.Contains(v2rayRouting, o.Protocol) {
+			continue
+		}
+		reject := func(err error) {
+			errs = append(errs, LineError{Line: i + 1, Input: nodeName(o.Tag, o.Protocol), Reason: err.Error()})
+		}
+
+		var scheme string
+		var tls *models.ProxyTLS
+		if o.StreamSettings.Security == "tls" {
+			tls = &models.ProxyTLS{ServerName: o.StreamSettings.TLSSettings.ServerName, Insecure: o.StreamSettings.TLSSettings.AllowInsecure}
+		}
+		switch o.Protocol {
+		case "http":
+			scheme = "http"
+			if tls != nil {
+				scheme = "https"
+			}
+		case "socks":
+			scheme = "socks5"
+		default:
+			reject(fmt.Errorf("unsupported outbound protocol %q", o.Protocol))
+			continue
+		}
+		if len(o.Settings.Servers) == 0 {
+			reject(errors.New("no servers"))
+			continue
+		}
+
+		for _, srv := range o.Settings.Servers {
+			host, port, err := serverPort(srv.Address, strconv.Itoa(srv.Port))
+			if err != nil {
+				reject(err)
+				continue
+			}
+			p := models.ProxyInput{Scheme: scheme, Host: host, Port: port, Label: o.Tag, TLS: tls, Line: i + 1}
+			if len(srv.Users) == 0 {
+				proxies = append(proxies, p)
+			}
+			for _, u := range srv.Users {
+				p.Username, p.Password = u.User, u.Pass
+				proxies = append(proxies, p)
+			}
+		}
+	}
+	return proxies, errs, nil
+}
+
+// serverPort validates the server address and port of a config node.
+func serverPort(server, port string) (string, string, error) {
+	server = strings.Trim(server, "[]")
+	if addr, err := netip.ParseAddr(server); err == nil && addr.Is6() {
+		return hostPort("["+server+"]", port)
+	}
+	return hostPort(server, port)
+}
+
+func nodeName(name, kind string) string {
+	if name == "" {
+		return kind
+	}
+	return name + " (" + kind + ")"
+}
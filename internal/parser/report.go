@@ -0,0 +1,241 @@
+package parser
+
+import (
+	"cmp"
+	"errors"
+	"ip-proxy-checker/internal/models"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParseOptions controls how Parse post-processes the accepted entries.
+type ParseOptions struct {
+	Dedupe  bool `json:"dedupe"`  // keep only the first occurrence of each entry
+	Sort    bool `json:"sort"`    // order IPs, then proxies, by address
+	Extract bool `json:"extract"` // find IPs anywhere in free text instead of one entry per line
+}
+
+// Duplicate lists every line that normalized to the same entry.
+type Duplicate struct {
+	Normalized string   `json:"normalized"`
+	Lines      []int    `json:"lines"`
+	Inputs     []string `json:"inputs"`
+}
+
+// ParseReport explains what became of every line of a parsed list.
+type ParseReport struct {
+	IPs        []models.IPInput    `json:"ips"`
+	Proxies    []models.ProxyInput `json:"proxies"`
+	Rejected   []LineError         `json:"rejected"`
+	Duplicates []Duplicate         `json:"duplicates"`
+	Normalized []string            `json:"normalized"` // accepted entries in canonical form
+	Total      int                 `json:"total"`      // accepted entries
+
+	// Extract mode only: every address found, most frequent first
+	Extracted []ExtractedIP `json:"extracted,omitempty"`
+
+	// Uploads only: how the file was read
+	File *FileInfo `json:"file,omitempty"`
+}
+
+type entry struct {
+	line       int
+	raw        string
+	normalized string
+	ip         *models.IPInput
+	proxy      *models.ProxyInput
+	addr       netip.Addr // zero for hostnames
+	host       string
+	port       int
+}
+
+// Parse sorts every non-empty line into an IP, a proxy or a rejected line,
+// and reports entries that appear more than once, in any format.
+func Parse(input string, opts ParseOptions) ParseReport {
+	if opts.Extract {
+		return extractReport(newReport(), input, opts)
+	}
+	b := newReportBuilder(opts)
+	for i, line := range strings.Split(input, "\n") {
+		b.add(i+1, line, line)
+	}
+	return b.finish()
+}
+
+func newReport() ParseReport {
+	return ParseReport{
+		IPs:        []models.IPInput{},
+		Proxies:    []models.ProxyInput{},
+		Rejected:   []LineError{},
+		Duplicates: []Duplicate{},
+		Normalized: []string{},
+	}
+}
+
+// reportBuilder collects entries one at a time so that large inputs can be
+// streamed into a report.
+type reportBuilder struct {
+	opts    ParseOptions
+	report  ParseReport
+	entries []entry
+	seen    map[string]int // normalized -> index in report.Duplicates, or -1
+}
+
+func newReportBuilder(opts ParseOptions) *reportBuilder {
+	return &reportBuilder{opts: opts, report: newReport(), seen: make(map[string]int)}
+}
+
+// add parses token as entry lineNo; raw is what the input looked like and is
+// what rejections and duplicates quote.
+func (b *reportBuilder) add(lineNo int, raw, token string) {
+	raw, token = strings.TrimSpace(raw), strings.TrimSpace(token)
+	if token == "" || strings.HasPrefix(token, "#") {
+		return
+	}
+
+	e, err := parseEntry(token, lineNo)
+	if err != nil {
+		b.reject(lineNo, raw, err.Error())
+		return
+	}
+	e.raw = raw
+	b.addEntry(e)
+}
+
+// addProxy adds a proxy that was parsed elsewhere, such as from a config file.
+func (b *reportBuilder) addProxy(p models.ProxyInput, raw string) {
+	b.addEntry(proxyEntry(p, raw))
+}
+
+func (b *reportBuilder) addEntry(e entry) {
+	if idx, ok := b.seen[e.normalized]; ok {
+		if idx < 0 {
+			first := slices.IndexFunc(b.entries, func(x entry) bool { return x.normalized == e.normalized })
+			b.report.Duplicates = append(b.report.Duplicates, Duplicate{
+				Normalized: e.normalized,
+				Lines:      []int{b.entries[first].line},
+				Inputs:     []string{b.entries[first].raw},
+			})
+			idx = len(b.report.Duplicates) - 1
+			b.seen[e.normalized] = idx
+		}
+		b.report.Duplicates[idx].Lines = append(b.report.Duplicates[idx].Lines, e.line)
+		b.report.Duplicates[idx].Inputs = append(b.report.Duplicates[idx].Inputs, e.raw)
+		if b.opts.Dedupe {
+			return
+		}
+	} else {
+		b.seen[e.normalized] = -1
+	}
+	b.entries = append(b.entries, e)
+}
+
+func (b *reportBuilder) reject(lineNo int, raw, reason string) {
+	b.report.Rejected = append(b.report.Rejected, LineError{Line: lineNo, Input: raw, Reason: reason})
+}
+
+func (b *reportBuilder) finish() ParseReport {
+	if b.opts.Sort {
+		slices.SortStableFunc(b.entries, compareEntries)
+	}
+	for _, e := range b.entries {
+		if e.ip != nil {
+			b.report.IPs = append(b.report.IPs, *e.ip)
+		} else {
+			b.report.Proxies = append(b.report.Proxies, *e.proxy)
+		}
+		b.report.Normalized = append(b.report.Normalized, e.normalized)
+	}
+	b.report.Total = len(b.entries)
+	return b.report
+}
+
+// extractReport fills report with the addresses found in free text. Every
+// address is listed once; Dedupe does not apply.
+func extractReport(report ParseReport, input string, opts ParseOptions) ParseReport {
+	extracted := ExtractIPs(input)
+	entries := make([]entry, 0, len(extracted))
+	for _, x := range extracted {
+		addr := netip.MustParseAddr(x.IP)
+		ip := models.IPInput{IP: x.IP, Type: IPTypeSimple, Line: x.FirstLine, Category: x.Category}
+		entries = append(entries, entry{line: x.FirstLine, normalized: x.IP, ip: &ip, addr: addr})
+	}
+	if opts.Sort {
+		slices.SortStableFunc(entries, compareEntries)
+	}
+	for _, e := range entries {
+		report.IPs = append(report.IPs, *e.ip)
+		report.Normalized = append(report.Normalized, e.normalized)
+	}
+
+	slices.SortStableFunc(extracted, func(a, b ExtractedIP) int { return cmp.Compare(b.Count, a.Count) })
+	report.Extracted = extracted
+	report.Total = len(entries)
+	return report
+}
+
+func parseEntry(line string, lineNo int) (entry, error) {
+	ip, ipErr := ParseIPToken(line)
+	if ipErr == nil {
+		ip.Line = lineNo
+		e := entry{line: lineNo, raw: line, normalized: ip.IP, ip: &ip, host: ip.IP}
+		// Order CIDRs and ranges by their first address
+		first, _, _ := strings.Cut(strings.Split(ip.IP, "/")[0], "-")
+		if addr, err := netip.ParseAddr(first); err == nil {
+			e.addr = addr.Unmap()
+		}
+		return e, nil
+	}
+
+	p, err := ParseProxy(line)
+	if err != nil {
+		// A malformed CIDR or range is better explained by the IP parser
+		if !errors.Is(ipErr, errNotIPToken) {
+			return entry{}, ipErr
+		}
+		return entry{}, err
+	}
+	p.Line = lineNo
+	return proxyEntry(p, line), nil
+}
+
+func proxyEntry(p models.ProxyInput, raw string) entry {
+	e := entry{line: p.Line, raw: raw, proxy: &p, host: strings.ToLower(p.Host)}
+	if addr, err := netip.ParseAddr(p.Host); err == nil {
+		e.addr = addr.Unmap()
+		e.host = e.addr.String()
+	}
+	e.port, _ = strconv.Atoi(p.Port)
+	e.normalized = p.Normalized()
+	return e
+}
+
+// compareEntries orders IPs before proxies, IP literals before hostnames.
+func compareEntries(a, b entry) int {
+	if c := cmp.Compare(boolRank(a.ip == nil), boolRank(b.ip == nil)); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(boolRank(!a.addr.IsValid()), boolRank(!b.addr.IsValid())); c != 0 {
+		return c
+	}
+	if a.addr.IsValid() {
+		if c := a.addr.Compare(b.addr); c != 0 {
+			return c
+		}
+	} else if c := cmp.Compare(a.host, b.host); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(a.port, b.port); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.normalized, b.normalized)
+}
+
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
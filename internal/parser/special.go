@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"net/netip"
+)
+
+// Address categories from the IANA IPv4 and IPv6 special-purpose address
+// registries, plus multicast and broadcast.
+const (
+	CategoryPublic        = "public"
+	CategoryPrivate       = "private"
+	CategoryShared        = "shared" // carrier-grade NAT
+	CategoryLoopback      = "loopback"
+	CategoryLinkLocal     = "link_local"
+	CategoryDocumentation = "documentation"
+	CategoryBenchmarking  = "benchmarking"
+	CategoryMulticast     = "multicast"
+	CategoryBroadcast     = "broadcast"
+	CategoryUnspecified   = "unspecified"
+	CategoryReserved      = "reserved"
+)
+
+// SpecialRange is one entry of the special-purpose registries.
+type SpecialRange struct {
+	Prefix   netip.Prefix
+	Category string
+	Name     string
+}
+
+// specialRanges lists the ranges that are not globally routable. Entries the
+// registries mark as globally reachable (AS112, AMT, 64:ff9b::/96, 6to4) are
+// left out on purpose, unless a covering block is listed: those come first as
+// public exceptions. More specific prefixes come first.
+var specialRanges = []SpecialRange{
+	// Globally reachable blocks inside the ranges below
+	{netip.MustParsePrefix("192.0.0.9/32"), CategoryPublic, "Port Control Protocol Anycast (RFC 7723)"},
+	{netip.MustParsePrefix("192.0.0.10/32"), CategoryPublic, "Traversal Using Relays around NAT Anycast (RFC 8155)"},
+	{netip.MustParsePrefix("2001:1::1/128"), CategoryPublic, "Port Control Protocol Anycast (RFC 7723)"},
+	{netip.MustParsePrefix("2001:1::2/128"), CategoryPublic, "Traversal Using Relays around NAT Anycast (RFC 8155)"},
+	{netip.MustParsePrefix("2001:3::/32"), CategoryPublic, "AMT (RFC 7450)"},
+	{netip.MustParsePrefix("2001:4:112::/48"), CategoryPublic, "AS112-v6 (RFC 7535)"},
+	{netip.MustParsePrefix("2001:20::/28"), CategoryPublic, "ORCHIDv2 (RFC 7343)"},
+	{netip.MustParsePrefix("2001:30::/28"), CategoryPublic, "Drone Remote ID Protocol Entity Tags (RFC 9374)"},
+
+	// IPv4
+	{netip.MustParsePrefix("0.0.0.0/8"), CategoryUnspecified, "\"This network\" (RFC 791)"},
+	{netip.MustParsePrefix("10.0.0.0/8"), CategoryPrivate, "Private-Use (RFC 1918)"},
+	{netip.MustParsePrefix("100.64.0.0/10"), CategoryShared, "Shared Address Space (RFC 6598)"},
+	{netip.MustParsePrefix("127.0.0.0/8"), CategoryLoopback, "Loopback (RFC 1122)"},
+	{netip.MustParsePrefix("169.254.0.0/16"), CategoryLinkLocal, "Link Local (RFC 3927)"},
+	{netip.MustParsePrefix("172.16.0.0/12"), CategoryPrivate, "Private-Use (RFC 1918)"},
+	{netip.MustParsePrefix("192.0.0.0/24"), CategoryReserved, "IETF Protocol Assignments (RFC 6890)"},
+	{netip.MustParsePrefix("192.0.2.0/24"), CategoryDocumentation, "Documentation TEST-NET-1 (RFC 5737)"},
+	{netip.MustParsePrefix("192.88.99.0/24"), CategoryReserved, "Deprecated 6to4 Relay Anycast (RFC 7526)"},
+	{netip.MustParsePrefix("192.168.0.0/16"), CategoryPrivate, "Private-Use (RFC 1918)"},
+	{netip.MustParsePrefix("198.18.0.0/15"), CategoryBenchmarking, "Benchmarking (RFC 2544)"},
+	{netip.MustParsePrefix("198.51.100.0/24"), CategoryDocumentation, "Documentation TEST-NET-2 (RFC 5737)"},
+	{netip.MustParsePrefix("203.0.113.0/24"), CategoryDocumentation, "Documentation TEST-NET-3 (RFC 5737)"},
+	{netip.MustParsePrefix("224.0.0.0/4"), CategoryMulticast, "Multicast (RFC 5771)"},
+	{netip.MustParsePrefix("255.255.255.255/32"), CategoryBroadcast, "Limited Broadcast (RFC 919)"},
+	{netip.MustParsePrefix("240.0.0.0/4"), CategoryReserved, "Reserved (RFC 1112)"},
+
+	// IPv6
+	{netip.MustParsePrefix("::/128"), CategoryUnspecified, "Unspecified Address (RFC 4291)"},
+	{netip.MustParsePrefix("::1/128"), CategoryLoopback, "Loopback Address (RFC 4291)"},
+	{netip.MustParsePrefix("64:ff9b:1::/48"), CategoryReserved, "IPv4-IPv6 Translation, local use (RFC 8215)"},
+	{netip.MustParsePrefix("100::/64"), CategoryReserved, "Discard-Only Address Block (RFC 6666)"},
+	{netip.MustParsePrefix("2001:2::/48"), CategoryBenchmarking, "Benchmarking (RFC 5180)"},
+	{netip.MustParsePrefix("2001:db8::/32"), CategoryDocumentation, "Documentation (RFC 3849)"},
+	{netip.MustParsePrefix("2001:10::/28"), CategoryReserved, "Deprecated ORCHID (RFC 4843)"},
+	{netip.MustParsePrefix("2001::/23"), CategoryReserved, "IETF Protocol Assignments (RFC 2928)"},
+	{netip.MustParsePrefix("3fff::/20"), CategoryDocumentation, "Documentation (RFC 9637)"},
+	{netip.MustParsePrefix("5f00::/16"), CategoryReserved, "Segment Routing SIDs (RFC 9602)"},
+	{netip.MustParsePrefix("fc00::/7"), CategoryPrivate, "Unique-Local (RFC 4193)"},
+	{netip.MustParsePrefix("fe80::/10"), CategoryLinkLocal, "Link-Local Unicast (RFC 4291)"},
+	{netip.MustParsePrefix("ff00::/8"), CategoryMulticast, "Multicast (RFC 4291)"},
+}
+
+// ClassifyAddr returns the special-purpose range addr falls in, or a public
+// range when it is globally routable. IPv4-mapped IPv6 addresses are
+// classified as IPv4.
+func ClassifyAddr(addr netip.Addr) SpecialRange {
+	addr = addr.Unmap()
+	for _, r := range specialRanges {
+		if r.Prefix.Contains(addr) {
+			return r
+		}
+	}
+	return SpecialRange{Category: CategoryPublic}
+}
+
+// IPCategory classifies a textual IP; it returns "" for invalid input.
+func IPCategory(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	return ClassifyAddr(addr).Category
+}
+
+// IsRoutable reports whether ip is a valid, globally routable address worth a remote lookup.
+func IsRoutable(ip string) bool {
+	return IPCategory(ip) == CategoryPublic
+}
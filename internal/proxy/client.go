@@ -1,7 +1,14 @@
 package proxy
 
 import (
+	"bufio"
+	"cmp"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"ip-proxy-checker/internal/models"
+	"ip-proxy-checker/internal/parser"
 	"net"
 	"net/http"
 	"net/url"
@@ -17,54 +24,22 @@ type ProxyClient struct {
 	HTTPClient *http.Client
 	UserAgent  string
 	Timeout    time.Duration
+
+	dial func(ctx context.Context, network, addr string) (net.Conn, error) // to a target, through the proxy
 }
 
+// NewProxyClient parses proxyStr in any format understood by parser.ParseProxy
+// and builds a client for it. Proxies without a scheme are treated as HTTP.
 func NewProxyClient(proxyStr string, userAgent string, timeout time.Duration) (*ProxyClient, error) {
-	// Normalize proxy string.
-	finalProxyStr := strings.TrimSpace(proxyStr)
-	scheme := "http"
-	if strings.Contains(finalProxyStr, "://") {
-		parts := strings.SplitN(finalProxyStr, "://", 2)
-		scheme = parts[0]
-		finalProxyStr = parts[1]
-	}
-
-	// Advanced Parsing for various formats:
-	// 1. IP:Port:User:Pass
-	// 2. User:Pass@IP:Port
-	// 3. IP:Port
-	var host string
-	var user *url.Userinfo
-
-	if strings.Contains(finalProxyStr, "@") {
-		// Format: User:Pass@IP:Port
-		parts := strings.SplitN(finalProxyStr, "@", 2)
-		userInfo := parts[0]
-		host = parts[1]
-		if uParts := strings.SplitN(userInfo, ":", 2); len(uParts) == 2 {
-			user = url.UserPassword(uParts[0], uParts[1])
-		} else {
-			user = url.User(userInfo)
-		}
-	} else {
-		parts := strings.Split(finalProxyStr, ":")
-		if len(parts) >= 4 {
-			// Format: IP:Port:User:Pass
-			host = net.JoinHostPort(parts[0], parts[1])
-			user = url.UserPassword(parts[2], parts[3])
-		} else if len(parts) >= 2 {
-			// Format: IP:Port
-			host = net.JoinHostPort(parts[0], parts[1])
-		} else {
-			host = finalProxyStr
-		}
+	input, err := parser.ParseProxy(proxyStr)
+	if err != nil {
+		return nil, err
 	}
+	return NewProxyClientFromInput(input, userAgent, timeout)
+}
 
-	proxyURL := &url.URL{
-		Scheme: scheme,
-		Host:   host,
-		User:   user,
-	}
+func NewProxyClientFromInput(input models.ProxyInput, userAgent string, timeout time.Duration) (*ProxyClient, error) {
+	proxyURL := input.URL("http")
 
 	// Logging (SAFE: Do not modify the original proxyURL object)
 	displayUser := "none"
@@ -83,20 +58,47 @@ func NewProxyClient(proxyStr string, userAgent string, timeout time.Duration) (*
 	}
 
 	transport := &http.Transport{}
+	var dial func(ctx context.Context, network, addr string) (net.Conn, error)
 
 	// proxy.FromURL only supports socks5, socks4, socks4a.
 	// For http/https, we use the standard transport.Proxy.
 	if strings.HasPrefix(proxyURL.Scheme, "http") {
 		transport.Proxy = http.ProxyURL(proxyURL)
 		transport.DialContext = baseDialer.DialContext
+		if proxyURL.Scheme == "https" && input.TLS != nil {
+			// Only used for the TLS connection to the proxy itself, so the
+			// settings do not leak into the TLS sessions with the targets
+			tlsDialer := &tls.Dialer{NetDialer: baseDialer, Config: proxyTLSConfig(input)}
+			transport.DialTLSContext = tlsDialer.DialContext
+		}
+		proxyDial := baseDialer.DialContext
+		if proxyURL.Scheme == "https" {
+			cfg := &tls.Config{ServerName: input.Host}
+			if input.TLS != nil {
+				cfg = proxyTLSConfig(input)
+			}
+			proxyDial = (&tls.Dialer{NetDialer: baseDialer, Config: cfg}).DialContext
+		}
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return connectTunnel(ctx, proxyDial, proxyURL, addr)
+		}
 	} else {
-		dialer, err := proxy.FromURL(proxyURL, baseDialer)
+		var forward proxy.Dialer = baseDialer
+		if input.TLS != nil {
+			forward = &tls.Dialer{NetDialer: baseDialer, Config: proxyTLSConfig(input)}
+		}
+		dialer, err := proxy.FromURL(proxyURL, forward)
 		if err != nil {
 			return nil, err
 		}
-		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return dialer.Dial(network, addr)
+		if cd, ok := dialer.(proxy.ContextDialer); ok {
+			transport.DialContext = cd.DialContext
+		} else {
+			transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialWithContext(ctx, dialer, network, addr)
+			}
 		}
+		dial = transport.DialContext
 	}
 	client := &http.Client{
 		Transport: transport,
@@ -108,12 +110,103 @@ func NewProxyClient(proxyStr string, userAgent string, timeout time.Duration) (*
 		HTTPClient: client,
 		UserAgent:  userAgent,
 		Timeout:    timeout,
+		dial:       dial,
 	}, nil
 }
 
-func (pc *ProxyClient) RawTCPCheck() error {
+func proxyTLSConfig(input models.ProxyInput) *tls.Config {
+	return &tls.Config{
+		ServerName:         cmp.Or(input.TLS.ServerName, input.Host),
+		InsecureSkipVerify: input.TLS.Insecure,
+	}
+}
+
+// dialWithContext makes a dialer without context support return as soon as ctx is done.
+func dialWithContext(ctx context.Context, dialer proxy.Dialer, network, addr string) (net.Conn, error) {
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan dialResult, 1)
+	go func() {
+		conn, err := dialer.Dial(network, addr)
+		done <- dialResult{conn, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.conn, res.err
+	case <-ctx.Done():
+		// Close the connection if the dial completes after we gave up
+		go func() {
+			if res := <-done; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// connectTunnel asks an HTTP proxy for a CONNECT tunnel to addr.
+func connectTunnel(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), proxyURL *url.URL, addr string) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		pass, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pass)))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT: %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn keeps the bytes read past the CONNECT response.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// DialContext opens a connection to addr through the proxy: a CONNECT tunnel
+// for HTTP proxies, a SOCKS connect otherwise.
+func (pc *ProxyClient) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return pc.dial(ctx, network, addr)
+}
+
+func (pc *ProxyClient) RawTCPCheck(ctx context.Context) error {
 	dialer := &net.Dialer{Timeout: pc.Timeout}
-	conn, err := dialer.Dial("tcp", pc.Proxy.Host)
+	conn, err := dialer.DialContext(ctx, "tcp", pc.Proxy.Host)
 	if err != nil {
 		return err
 	}
@@ -121,8 +214,17 @@ func (pc *ProxyClient) RawTCPCheck() error {
 	return nil
 }
 
-func (pc *ProxyClient) TestConnectivity() (bool, error) {
-	resp, err := pc.HTTPClient.Get("https://www.google.com")
+// Get fetches target through the proxy, bounded by ctx.
+func (pc *ProxyClient) Get(ctx context.Context, target string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	return pc.HTTPClient.Do(req)
+}
+
+func (pc *ProxyClient) TestConnectivity(ctx context.Context) (bool, error) {
+	resp, err := pc.Get(ctx, "https://www.google.com")
 	if err != nil {
 		return false, err
 	}
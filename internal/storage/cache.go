@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -14,6 +15,20 @@ type Cache struct {
 	db *sql.DB
 }
 
+type CacheEntry struct {
+	Value     string
+	Provider  string
+	IP        string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+type CacheStats struct {
+	Total      int            `json:"total"`
+	Expired    int            `json:"expired"`
+	ByProvider map[string]int `json:"by_provider"`
+}
+
 func NewCache(dbPath string) (*Cache, error) {
 	// Ensure directory exists
 	dir := filepath.Dir(dbPath)
@@ -35,36 +50,130 @@ func NewCache(dbPath string) (*Cache, error) {
 		return nil, err
 	}
 
+	// Columns added after the initial schema; older databases are migrated in place
+	for _, col := range []string{"created_at DATETIME", "provider TEXT", "ip TEXT"} {
+		if _, err := db.Exec("ALTER TABLE cache ADD COLUMN " + col); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			return nil, err
+		}
+	}
+	if _, err := db.Exec("CREATE INDEX IF NOT EXISTS idx_cache_provider_ip ON cache (provider, ip)"); err != nil {
+		return nil, err
+	}
+
 	return &Cache{db: db}, nil
 }
 
 func (c *Cache) Get(key string) (string, bool) {
-	var value string
-	var expiresAt time.Time
-	err := c.db.QueryRow("SELECT value, expires_at FROM cache WHERE key = ?", key).Scan(&value, &expiresAt)
-	if err != nil {
+	entry, ok := c.GetEntry(key)
+	if !ok {
 		return "", false
 	}
+	return entry.Value, true
+}
 
-	if time.Now().After(expiresAt) {
+// GetEntry returns an unexpired entry together with its metadata.
+func (c *Cache) GetEntry(key string) (CacheEntry, bool) {
+	var entry CacheEntry
+	var createdAt sql.NullTime
+	var provider, ip sql.NullString
+	err := c.db.QueryRow("SELECT value, expires_at, created_at, provider, ip FROM cache WHERE key = ?", key).
+		Scan(&entry.Value, &entry.ExpiresAt, &createdAt, &provider, &ip)
+	if err != nil {
+		return CacheEntry{}, false
+	}
+
+	if time.Now().After(entry.ExpiresAt) {
 		c.db.Exec("DELETE FROM cache WHERE key = ?", key)
-		return "", false
+		return CacheEntry{}, false
 	}
 
-	return value, true
+	entry.CreatedAt = createdAt.Time
+	entry.Provider = provider.String
+	entry.IP = ip.String
+	return entry, true
 }
 
 func (c *Cache) Set(key string, value interface{}, ttl time.Duration) error {
+	return c.SetEntry(key, "", "", value, ttl)
+}
+
+// SetEntry stores a value tagged with the provider and IP it belongs to, so it can be purged by either.
+func (c *Cache) SetEntry(key, provider, ip string, value interface{}, ttl time.Duration) error {
 	valBytes, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
 
-	expiresAt := time.Now().Add(ttl)
-	_, err = c.db.Exec("INSERT OR REPLACE INTO cache (key, value, expires_at) VALUES (?, ?, ?)", key, string(valBytes), expiresAt)
+	now := time.Now().UTC()
+	_, err = c.db.Exec("INSERT OR REPLACE INTO cache (key, value, expires_at, created_at, provider, ip) VALUES (?, ?, ?, ?, ?, ?)",
+		key, string(valBytes), now.Add(ttl), now, provider, ip)
 	return err
 }
 
+func (c *Cache) Stats() (CacheStats, error) {
+	stats := CacheStats{ByProvider: make(map[string]int)}
+	now := time.Now().UTC()
+
+	rows, err := c.db.Query("SELECT COALESCE(provider, ''), COUNT(*), SUM(CASE WHEN expires_at < ? THEN 1 ELSE 0 END) FROM cache GROUP BY provider", now)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var provider string
+		var count, expired int
+		if err := rows.Scan(&provider, &count, &expired); err != nil {
+			return stats, err
+		}
+		if provider == "" {
+			provider = "other"
+		}
+		stats.ByProvider[provider] += count
+		stats.Total += count
+		stats.Expired += expired
+	}
+	return stats, rows.Err()
+}
+
+// Purge deletes entries matching the IP and/or provider. Empty filters match everything.
+func (c *Cache) Purge(ip, provider string) (int64, error) {
+	query := "DELETE FROM cache WHERE 1 = 1"
+	var args []interface{}
+	if ip != "" {
+		query += " AND ip = ?"
+		args = append(args, ip)
+	}
+	if provider != "" {
+		query += " AND provider = ?"
+		args = append(args, provider)
+	}
+
+	res, err := c.db.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (c *Cache) DeleteExpired() (int64, error) {
+	res, err := c.db.Exec("DELETE FROM cache WHERE expires_at < ?", time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// Vacuum deletes expired rows and reclaims the freed space.
+func (c *Cache) Vacuum() (int64, error) {
+	deleted, err := c.DeleteExpired()
+	if err != nil {
+		return 0, err
+	}
+	_, err = c.db.Exec("VACUUM")
+	return deleted, err
+}
+
 func (c *Cache) Close() error {
 	return c.db.Close()
 }
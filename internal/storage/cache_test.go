@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheEntryAndPurge(t *testing.T) {
+	cache, err := NewCache(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatalf("NewCache failed: %v", err)
+	}
+	defer cache.Close()
+
+	cache.SetEntry("ipwho:1.1.1.1", "ipwho", "1.1.1.1", map[string]string{"ip": "1.1.1.1"}, time.Hour)
+	cache.SetEntry("ipapi:1.1.1.1", "ipapi", "1.1.1.1", map[string]string{"ip": "1.1.1.1"}, time.Hour)
+	cache.SetEntry("ipwho:8.8.8.8", "ipwho", "8.8.8.8", map[string]string{"ip": "8.8.8.8"}, -time.Minute)
+
+	entry, ok := cache.GetEntry("ipwho:1.1.1.1")
+	if !ok {
+		t.Fatalf("Expected cache hit")
+	}
+	if entry.Provider != "ipwho" || entry.CreatedAt.IsZero() {
+		t.Errorf("Entry metadata not stored: %+v", entry)
+	}
+
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Fatalf("Stats failed: %v", err)
+	}
+	if stats.Total != 3 || stats.Expired != 1 || stats.ByProvider["ipwho"] != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+
+	if deleted, _ := cache.DeleteExpired(); deleted != 1 {
+		t.Errorf("Expected 1 expired row deleted, got %d", deleted)
+	}
+	if deleted, _ := cache.Purge("1.1.1.1", ""); deleted != 2 {
+		t.Errorf("Expected 2 rows purged by IP, got %d", deleted)
+	}
+	if _, ok := cache.Get("ipapi:1.1.1.1"); ok {
+		t.Errorf("Expected purged entry to be gone")
+	}
+}
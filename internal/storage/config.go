@@ -7,60 +7,274 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// ProviderConfig holds the settings shared by every upstream lookup provider.
+type ProviderConfig struct {
+	RateLimit float64       `yaml:"rate_limit"` // requests per second, 0 = unlimited
+	Burst     int           `yaml:"burst"`
+	Timeout   time.Duration `yaml:"timeout"`
+	CacheTTL  time.Duration `yaml:"cache_ttl"`
+
+	// Override circuit_breaker defaults for this provider when set
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+}
+
+// SubscriptionConfig is a remote proxy or IP list fetched on a schedule.
+type SubscriptionConfig struct {
+	Name      string              `yaml:"name"`
+	URL       string              `yaml:"url"`
+	Headers   map[string]string   `yaml:"headers"`   // sent with every fetch, e.g. Authorization
+	Format    string              `yaml:"format"`    // plain, csv or json; guessed when empty
+	Delimiter string              `yaml:"delimiter"` // csv only, detected when empty
+	Header    string              `yaml:"header"`    // csv only: yes, no or detected when empty
+	Columns   SubscriptionColumns `yaml:"columns"`
+	Interval  time.Duration       `yaml:"interval"`
+}
+
+// SubscriptionColumns names the csv columns or json keys of host, port, user,
+// pass and protocol. It converts to parser.Columns.
+type SubscriptionColumns struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Pass     string `yaml:"pass"`
+	Protocol string `yaml:"protocol"`
+}
+
+// PACRuleConfig is one rule of the served PAC file. It converts to export.PACRule.
+type PACRuleConfig struct {
+	Domains        []string `yaml:"domains"` // example.com (and its subdomains) or a shell pattern like *.example.*
+	Country        string   `yaml:"country"`
+	Tag            string   `yaml:"tag"` // a proxy tag or config node name
+	ConnectionType string   `yaml:"connection_type"`
+	Failover       int      `yaml:"failover"` // proxies tried in turn
+	Direct         bool     `yaml:"direct"`   // go direct once they all failed
+}
+
 type Config struct {
 	API struct {
-		IPWho struct {
-			RateLimit int           `yaml:"rate_limit"`
-			Timeout   time.Duration `yaml:"timeout"`
-			CacheTTL  time.Duration `yaml:"cache_ttl"`
-		} `yaml:"ipwho"`
+		IPWho     ProviderConfig `yaml:"ipwho"`
+		IPApi     ProviderConfig `yaml:"ipapi"`
 		IPQuality struct {
-			APIKey     string        `yaml:"api_key"`
-			Timeout    time.Duration `yaml:"timeout"`
-			UserAgents []string      `yaml:"user_agents"`
-			DelayRange []int         `yaml:"delay_range"`
+			ProviderConfig `yaml:",inline"`
+			APIKey         string   `yaml:"api_key"`
+			UserAgents     []string `yaml:"user_agents"`
+			DelayRange     []int    `yaml:"delay_range"`
 		} `yaml:"ipquality"`
+		Scamalytics ProviderConfig `yaml:"scamalytics"`
+		AbuseIPDB   ProviderConfig `yaml:"abuseipdb"`
 	} `yaml:"api"`
 	Worker struct {
 		PoolSize      int           `yaml:"pool_size"`
 		RetryAttempts int           `yaml:"retry_attempts"`
 		RetryDelay    time.Duration `yaml:"retry_delay"`
+		RetryMaxDelay time.Duration `yaml:"retry_max_delay"`
+
+		// Adaptive concurrency starts at pool_size and moves between the bounds
+		Adaptive struct {
+			Enabled          bool    `yaml:"enabled"`
+			MinConcurrency   int     `yaml:"min_concurrency"`
+			MaxConcurrency   int     `yaml:"max_concurrency"`
+			BackoffFactor    float64 `yaml:"backoff_factor"`
+			ErrorThreshold   float64 `yaml:"error_threshold"`
+			LatencyTolerance float64 `yaml:"latency_tolerance"`
+		} `yaml:"adaptive"`
 	} `yaml:"worker"`
+	Scheduler struct {
+		MaxConcurrency  int                `yaml:"max_concurrency"`  // checks running at once across all requests
+		PriorityWeights map[string]float64 `yaml:"priority_weights"` // interactive / scheduled
+		TenantWeights   map[string]float64 `yaml:"tenant_weights"`   // keyed by tenant, e.g. "user:alice"
+	} `yaml:"scheduler"`
+	Jobs struct {
+		Retention time.Duration `yaml:"retention"` // finished jobs and their results are kept this long, 0 = not at all
+	} `yaml:"jobs"`
+	Deadlines struct {
+		PerIP        time.Duration `yaml:"per_ip"`    // whole whois lookup of one IP
+		PerProxy     time.Duration `yaml:"per_proxy"` // whole quality check of one proxy
+		TCPCheck     time.Duration `yaml:"tcp_check"`
+		Connectivity time.Duration `yaml:"connectivity"`
+		Reputation   time.Duration `yaml:"reputation"`
+	} `yaml:"deadlines"`
+	CircuitBreaker struct {
+		FailureThreshold int           `yaml:"failure_threshold"`
+		Cooldown         time.Duration `yaml:"cooldown"`
+	} `yaml:"circuit_breaker"`
 	Proxy struct {
 		ConnectionTimeout time.Duration `yaml:"connection_timeout"`
 		Types             []string      `yaml:"types"`
+		AllowPrivateHosts bool          `yaml:"allow_private_hosts"` // check proxies on private, loopback and other non-routable IPs
+
+		// Applied before the TCP check and connectivity dials, 0 = unlimited
+		DialLimits struct {
+			PerHost       int           `yaml:"per_host"`
+			PerSubnet     int           `yaml:"per_subnet"` // /24 for IPv4, /48 for IPv6
+			HostSpacing   time.Duration `yaml:"host_spacing"`
+			SubnetSpacing time.Duration `yaml:"subnet_spacing"`
+		} `yaml:"dial_limits"`
 	} `yaml:"proxy"`
+	Parser struct {
+		MaxExpansion int           `yaml:"max_expansion"` // addresses one whois request may expand CIDRs, ranges and hostnames to
+		MaxUploadMB  int           `yaml:"max_upload_mb"` // size limit of an uploaded list
+		ImportTTL    time.Duration `yaml:"import_ttl"`    // how long an upload can be referred to by its import ID
+	} `yaml:"parser"`
+	Subscriptions struct {
+		Timeout time.Duration        `yaml:"timeout"` // one fetch, including the download
+		Sources []SubscriptionConfig `yaml:"sources"`
+	} `yaml:"subscriptions"`
+	PAC struct {
+		Default string          `yaml:"default"` // result for hosts no rule matches
+		Rules   []PACRuleConfig `yaml:"rules"`
+	} `yaml:"pac"`
+	Gateway struct {
+		Enabled         bool          `yaml:"enabled"`
+		HTTPListen      string        `yaml:"http_listen"`  // empty disables the HTTP proxy
+		SOCKSListen     string        `yaml:"socks_listen"` // empty disables the SOCKS5 proxy
+		Password        string        `yaml:"password"`     // required from clients when set
+		Strategy        string        `yaml:"strategy"`     // round-robin, random, least-latency or sticky
+		SessionTTL      time.Duration `yaml:"session_ttl"`  // how long a sticky session keeps its upstream
+		DialTimeout     time.Duration `yaml:"dial_timeout"` // one connection through one upstream
+		Retries         int           `yaml:"retries"`      // other upstreams tried after a failed dial
+		MaxFailures     int           `yaml:"max_failures"` // consecutive failures that evict an upstream
+		EvictFor        time.Duration `yaml:"evict_for"`
+		RefreshInterval time.Duration `yaml:"refresh_interval"` // how often the live pool is reloaded
+	} `yaml:"gateway"`
+	Pool struct {
+		DefaultTTL      time.Duration `yaml:"default_ttl"` // lease TTL when the request sets none
+		MaxTTL          time.Duration `yaml:"max_ttl"`
+		RecheckInterval time.Duration `yaml:"recheck_interval"` // how often proxies reported bad are rechecked
+	} `yaml:"pool"`
 	Storage struct {
-		CacheEnabled bool   `yaml:"cache_enabled"`
-		DBPath       string `yaml:"db_path"`
+		CacheEnabled   bool          `yaml:"cache_enabled"`
+		DBPath         string        `yaml:"db_path"`
+		VacuumInterval time.Duration `yaml:"vacuum_interval"`
+		InventoryPath  string        `yaml:"inventory_path"` // proxy inventory, kept apart from the disposable cache
+		HistorySize    int           `yaml:"history_size"`   // checks kept per inventory proxy
 	} `yaml:"storage"`
+	Classifier struct {
+		ASNFile         string `yaml:"asn_file"`
+		CloudRangesFile string `yaml:"cloud_ranges_file"`
+		RDNSLookup      bool   `yaml:"rdns_lookup"`
+	} `yaml:"classifier"`
 }
 
 var DefaultConfig = `
 api:
   ipwho:
     rate_limit: 10
-    timeout: 30s
+    burst: 10
+    timeout: 10s
+    cache_ttl: 24h
+  ipapi:
+    rate_limit: 0.7
+    burst: 3
+    timeout: 10s
     cache_ttl: 24h
   ipquality:
     api_key: ""
+    rate_limit: 5
+    burst: 5
     timeout: 30s
+    cache_ttl: 6h
     user_agents:
       - "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36"
     delay_range: [1000, 3000]
+  scamalytics:
+    rate_limit: 1
+    burst: 2
+    timeout: 30s
+    cache_ttl: 12h
+  abuseipdb:
+    rate_limit: 1
+    burst: 2
+    timeout: 30s
+    cache_ttl: 12h
 
 worker:
   pool_size: 5
   retry_attempts: 3
   retry_delay: 2s
+  retry_max_delay: 30s
+  adaptive:
+    enabled: true
+    min_concurrency: 2
+    max_concurrency: 50
+    backoff_factor: 0.5
+    error_threshold: 0.2
+    latency_tolerance: 1.5
+
+scheduler:
+  max_concurrency: 20
+  priority_weights:
+    interactive: 4
+    scheduled: 1
+  tenant_weights: {}
+
+jobs:
+  retention: 1h
+
+deadlines:
+  per_ip: 60s
+  per_proxy: 120s
+  tcp_check: 10s
+  connectivity: 45s
+  reputation: 90s
+
+circuit_breaker:
+  failure_threshold: 5
+  cooldown: 60s
 
 proxy:
   connection_timeout: 30s
   types: ["http", "https", "socks5"]
+  allow_private_hosts: false
+  dial_limits:
+    per_host: 4
+    per_subnet: 16
+    host_spacing: 250ms
+    subnet_spacing: 50ms
+
+parser:
+  max_expansion: 4096
+  max_upload_mb: 64
+  import_ttl: 24h
+
+subscriptions:
+  timeout: 60s
+  sources: []
+
+pac:
+  default: DIRECT
+  rules: []
+
+gateway:
+  enabled: false
+  http_listen: "127.0.0.1:8081"
+  socks_listen: "127.0.0.1:1081"
+  password: ""
+  strategy: round-robin
+  session_ttl: 10m
+  dial_timeout: 10s
+  retries: 2
+  max_failures: 3
+  evict_for: 5m
+  refresh_interval: 30s
+
+pool:
+  default_ttl: 10m
+  max_ttl: 24h
+  recheck_interval: 1m
 
 storage:
   cache_enabled: true
   db_path: "./data/cache.db"
+  vacuum_interval: 1h
+  inventory_path: "./data/inventory.db"
+  history_size: 20
+
+classifier:
+  asn_file: "./data/asn_types.csv"
+  cloud_ranges_file: ""
+  rdns_lookup: true
 `
 
 func LoadConfig(path string) (*Config, error) {
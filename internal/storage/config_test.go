@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestDefaultConfigMatchesShippedAPISettings(t *testing.T) {
+	data, err := os.ReadFile("../../config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var defaults, shipped Config
+	if err := yaml.Unmarshal([]byte(DefaultConfig), &defaults); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(data, &shipped); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(defaults.API, shipped.API) {
+		t.Errorf("DefaultConfig api section = %+v, config.yaml = %+v", defaults.API, shipped.API)
+	}
+}
@@ -0,0 +1,421 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"ip-proxy-checker/internal/models"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+var (
+	ErrProxyNotFound = errors.New("proxy not found in the inventory")
+	ErrProxyExists   = errors.New("proxy is already in the inventory")
+)
+
+// Inventory keeps the managed proxies with their metadata, last check result
+// and a rolling check history. Proxies are identified by their normalized
+// form, so the same proxy imported twice is one entry.
+type Inventory struct {
+	db          *sql.DB
+	historySize int
+}
+
+// InventoryProxy is one proxy of the inventory.
+type InventoryProxy struct {
+	ID          int64                   `json:"id"`
+	Proxy       models.ProxyInput       `json:"proxy"`
+	Supplier    string                  `json:"supplier,omitempty"`
+	CostGroup   string                  `json:"cost_group,omitempty"`
+	Tags        []string                `json:"tags"`
+	Status      string                  `json:"status,omitempty"` // of the last check, empty until checked
+	LastResult  *models.IPQualityResult `json:"last_result,omitempty"`
+	LastChecked *time.Time              `json:"last_checked,omitempty"`
+	FirstSeen   time.Time               `json:"first_seen"`
+	LastSeen    time.Time               `json:"last_seen"` // last import listing it or live check
+}
+
+// InventoryCheck is one entry of a proxy's check history.
+type InventoryCheck struct {
+	CheckedAt  time.Time `json:"checked_at"`
+	Status     string    `json:"status"`
+	IP         string    `json:"ip,omitempty"` // exit IP when live
+	Country    string    `json:"country,omitempty"`
+	FraudScore string    `json:"fraud_score,omitempty"`
+	LatencyMS  int64     `json:"latency_ms,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// InventoryFilter selects inventory proxies. Empty fields match everything.
+type InventoryFilter struct {
+	Tag       string
+	Supplier  string
+	CostGroup string
+	Status    string
+	Limit     int // 0 for no limit
+	Offset    int
+}
+
+// InventoryImport counts the proxies a bulk import added and updated.
+type InventoryImport struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+}
+
+func NewInventory(dbPath string, historySize int) (*Inventory, error) {
+	dir := filepath.Dir(dbPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS proxies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			key TEXT NOT NULL UNIQUE,
+			proxy TEXT NOT NULL,
+			supplier TEXT NOT NULL DEFAULT '',
+			cost_group TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT '[]',
+			status TEXT NOT NULL DEFAULT '',
+			last_result TEXT,
+			last_checked DATETIME,
+			first_seen DATETIME NOT NULL,
+			last_seen DATETIME NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS proxy_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			proxy_id INTEGER NOT NULL,
+			checked_at DATETIME NOT NULL,
+			status TEXT NOT NULL,
+			ip TEXT,
+			country TEXT,
+			fraud_score TEXT,
+			latency_ms INTEGER,
+			error TEXT
+		)`,
+		"CREATE INDEX IF NOT EXISTS idx_proxies_supplier ON proxies (supplier)",
+		"CREATE INDEX IF NOT EXISTS idx_proxies_cost_group ON proxies (cost_group)",
+		"CREATE INDEX IF NOT EXISTS idx_proxy_history_proxy ON proxy_history (proxy_id, id)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	if historySize <= 0 {
+		historySize = 20
+	}
+	return &Inventory{db: db, historySize: historySize}, nil
+}
+
+const inventoryColumns = "id, proxy, supplier, cost_group, tags, status, last_result, last_checked, first_seen, last_seen"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanInventoryProxy(row rowScanner) (InventoryProxy, error) {
+	var p InventoryProxy
+	var proxy, tags string
+	var lastResult sql.NullString
+	var lastChecked sql.NullTime
+	if err := row.Scan(&p.ID, &proxy, &p.Supplier, &p.CostGroup, &tags, &p.Status, &lastResult, &lastChecked, &p.FirstSeen, &p.LastSeen); err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal([]byte(proxy), &p.Proxy); err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal([]byte(tags), &p.Tags); err != nil {
+		return p, err
+	}
+	if lastResult.Valid {
+		p.LastResult = &models.IPQualityResult{}
+		if err := json.Unmarshal([]byte(lastResult.String), p.LastResult); err != nil {
+			return p, err
+		}
+	}
+	if lastChecked.Valid {
+		p.LastChecked = &lastChecked.Time
+	}
+	return p, nil
+}
+
+// storedProxy is the proxy as saved: tags live in their own column and the
+// input line number means nothing outside the original list.
+func storedProxy(p models.ProxyInput) (string, error) {
+	p.Tags, p.Line = nil, 0
+	b, err := json.Marshal(p)
+	return string(b), err
+}
+
+// mergeTags appends the tags not yet in list, ignoring case and blanks.
+func mergeTags(list []string, tags ...string) []string {
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || slices.ContainsFunc(list, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			continue
+		}
+		list = append(list, tag)
+	}
+	if list == nil {
+		list = []string{}
+	}
+	return list
+}
+
+// Add stores a new proxy. It returns ErrProxyExists when the proxy is already in the inventory.
+func (inv *Inventory) Add(p InventoryProxy) (InventoryProxy, error) {
+	proxy, err := storedProxy(p.Proxy)
+	if err != nil {
+		return p, err
+	}
+	tags, err := json.Marshal(mergeTags(nil, slices.Concat(p.Proxy.Tags, p.Tags)...))
+	if err != nil {
+		return p, err
+	}
+	now := time.Now().UTC()
+	res, err := inv.db.Exec("INSERT INTO proxies (key, proxy, supplier, cost_group, tags, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		p.Proxy.Normalized(), proxy, p.Supplier, p.CostGroup, string(tags), now, now)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return p, ErrProxyExists
+		}
+		return p, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return p, err
+	}
+	return inv.Get(id)
+}
+
+// Import adds the proxies that are new and refreshes the last-seen time of
+// the known ones. Tags are merged into the existing ones; a supplier or cost
+// group replaces the stored one unless it is empty.
+func (inv *Inventory) Import(proxies []models.ProxyInput, supplier, costGroup string, tags []string) (InventoryImport, error) {
+	var result InventoryImport
+	tx, err := inv.db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, p := range proxies {
+		key := p.Normalized()
+		var id int64
+		var stored string
+		err := tx.QueryRow("SELECT id, tags FROM proxies WHERE key = ?", key).Scan(&id, &stored)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			proxy, err := storedProxy(p)
+			if err != nil {
+				return result, err
+			}
+			merged, err := json.Marshal(mergeTags(nil, slices.Concat(p.Tags, tags)...))
+			if err != nil {
+				return result, err
+			}
+			if _, err := tx.Exec("INSERT INTO proxies (key, proxy, supplier, cost_group, tags, first_seen, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				key, proxy, supplier, costGroup, string(merged), now, now); err != nil {
+				return result, err
+			}
+			result.Added++
+		case err != nil:
+			return result, err
+		default:
+			var existing []string
+			if err := json.Unmarshal([]byte(stored), &existing); err != nil {
+				return result, err
+			}
+			merged, err := json.Marshal(mergeTags(existing, slices.Concat(p.Tags, tags)...))
+			if err != nil {
+				return result, err
+			}
+			if _, err := tx.Exec("UPDATE proxies SET supplier = COALESCE(NULLIF(?, ''), supplier), cost_group = COALESCE(NULLIF(?, ''), cost_group), tags = ?, last_seen = ? WHERE id = ?",
+				supplier, costGroup, string(merged), now, id); err != nil {
+				return result, err
+			}
+			result.Updated++
+		}
+	}
+	return result, tx.Commit()
+}
+
+func (inv *Inventory) Get(id int64) (InventoryProxy, error) {
+	p, err := scanInventoryProxy(inv.db.QueryRow("SELECT "+inventoryColumns+" FROM proxies WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, ErrProxyNotFound
+	}
+	return p, err
+}
+
+// Update replaces the supplier, cost group and tags of a proxy.
+func (inv *Inventory) Update(id int64, supplier, costGroup string, tags []string) (InventoryProxy, error) {
+	merged, err := json.Marshal(mergeTags(nil, tags...))
+	if err != nil {
+		return InventoryProxy{}, err
+	}
+	res, err := inv.db.Exec("UPDATE proxies SET supplier = ?, cost_group = ?, tags = ? WHERE id = ?", supplier, costGroup, string(merged), id)
+	if err != nil {
+		return InventoryProxy{}, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return InventoryProxy{}, ErrProxyNotFound
+	}
+	return inv.Get(id)
+}
+
+// Delete removes a proxy and its history.
+func (inv *Inventory) Delete(id int64) error {
+	tx, err := inv.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM proxies WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrProxyNotFound
+	}
+	if _, err := tx.Exec("DELETE FROM proxy_history WHERE proxy_id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// List returns the proxies matching f in insertion order, and how many match
+// without the limit. Tags match case-insensitively.
+func (inv *Inventory) List(f InventoryFilter) ([]InventoryProxy, int, error) {
+	where := " WHERE 1 = 1"
+	var args []interface{}
+	if f.Tag != "" {
+		where += " AND EXISTS (SELECT 1 FROM json_each(proxies.tags) WHERE value = ? COLLATE NOCASE)"
+		args = append(args, f.Tag)
+	}
+	if f.Supplier != "" {
+		where += " AND supplier = ?"
+		args = append(args, f.Supplier)
+	}
+	if f.CostGroup != "" {
+		where += " AND cost_group = ?"
+		args = append(args, f.CostGroup)
+	}
+	if f.Status != "" {
+		where += " AND status = ? COLLATE NOCASE"
+		args = append(args, f.Status)
+	}
+
+	var total int
+	if err := inv.db.QueryRow("SELECT COUNT(*) FROM proxies"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := "SELECT " + inventoryColumns + " FROM proxies" + where + " ORDER BY id"
+	if f.Limit > 0 || f.Offset > 0 {
+		limit := f.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, f.Offset)
+	}
+	rows, err := inv.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	list := []InventoryProxy{}
+	for rows.Next() {
+		p, err := scanInventoryProxy(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, p)
+	}
+	return list, total, rows.Err()
+}
+
+// History returns the retained checks of a proxy, newest first.
+func (inv *Inventory) History(id int64) ([]InventoryCheck, error) {
+	if _, err := inv.Get(id); err != nil {
+		return nil, err
+	}
+	rows, err := inv.db.Query("SELECT checked_at, status, COALESCE(ip, ''), COALESCE(country, ''), COALESCE(fraud_score, ''), COALESCE(latency_ms, 0), COALESCE(error, '') FROM proxy_history WHERE proxy_id = ? ORDER BY id DESC", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := []InventoryCheck{}
+	for rows.Next() {
+		var c InventoryCheck
+		if err := rows.Scan(&c.CheckedAt, &c.Status, &c.IP, &c.Country, &c.FraudScore, &c.LatencyMS, &c.Error); err != nil {
+			return nil, err
+		}
+		history = append(history, c)
+	}
+	return history, rows.Err()
+}
+
+// RecordChecks stores check results as the last result of the inventory
+// proxies they belong to and appends them to their history, dropping the
+// oldest entries past the history size. Results of proxies outside the
+// inventory are ignored. It returns how many proxies were updated.
+func (inv *Inventory) RecordChecks(checked []models.CheckedProxy) (int, error) {
+	tx, err := inv.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	recorded := 0
+	for _, c := range checked {
+		var id int64
+		err := tx.QueryRow("SELECT id FROM proxies WHERE key = ?", c.Proxy.Normalized()).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return recorded, err
+		}
+		result, err := json.Marshal(c.Result)
+		if err != nil {
+			return recorded, err
+		}
+		if _, err := tx.Exec("UPDATE proxies SET status = ?, last_result = ?, last_checked = ?, last_seen = CASE WHEN ? = 'Live' THEN ? ELSE last_seen END WHERE id = ?",
+			c.Result.Status, string(result), now, c.Result.Status, now, id); err != nil {
+			return recorded, err
+		}
+		if _, err := tx.Exec("INSERT INTO proxy_history (proxy_id, checked_at, status, ip, country, fraud_score, latency_ms, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			id, now, c.Result.Status, c.Result.IP, c.Result.Country, c.Result.FraudScore, c.Result.LatencyMS, c.Result.Error); err != nil {
+			return recorded, err
+		}
+		if _, err := tx.Exec("DELETE FROM proxy_history WHERE proxy_id = ? AND id <= (SELECT id FROM proxy_history WHERE proxy_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?)",
+			id, id, inv.historySize); err != nil {
+			return recorded, err
+		}
+		recorded++
+	}
+	return recorded, tx.Commit()
+}
+
+func (inv *Inventory) Close() error {
+	return inv.db.Close()
+}
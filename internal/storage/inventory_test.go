@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"errors"
+	"ip-proxy-checker/internal/models"
+	"path/filepath"
+	"testing"
+)
+
+func TestInventoryImportAndHistory(t *testing.T) {
+	inv, err := NewInventory(filepath.Join(t.TempDir(), "inventory.db"), 2)
+	if err != nil {
+		t.Fatalf("NewInventory failed: %v", err)
+	}
+	defer inv.Close()
+
+	a := models.ProxyInput{Host: "1.1.1.1", Port: "8080", Username: "u", Password: "p", Line: 3}
+	b := models.ProxyInput{Host: "2.2.2.2", Port: "3128"}
+	res, err := inv.Import([]models.ProxyInput{a, b}, "acme", "cheap", []string{"residential"})
+	if err != nil || res.Added != 2 || res.Updated != 0 {
+		t.Fatalf("First import = %+v, %v", res, err)
+	}
+	res, err = inv.Import([]models.ProxyInput{{Host: "1.1.1.1", Port: "08080", Username: "u", Password: "p"}}, "", "premium", []string{"Residential", "eu"})
+	if err != nil || res.Added != 0 || res.Updated != 1 {
+		t.Fatalf("Re-import = %+v, %v", res, err)
+	}
+
+	list, total, err := inv.List(InventoryFilter{Tag: "EU"})
+	if err != nil || total != 1 || len(list) != 1 {
+		t.Fatalf("List by tag = %+v, %d, %v", list, total, err)
+	}
+	p := list[0]
+	if p.Supplier != "acme" || p.CostGroup != "premium" || len(p.Tags) != 2 || p.Proxy.Line != 0 || p.LastSeen.Before(p.FirstSeen) {
+		t.Errorf("Re-import did not merge the metadata: %+v", p)
+	}
+	if _, err := inv.Add(InventoryProxy{Proxy: a}); !errors.Is(err, ErrProxyExists) {
+		t.Errorf("Expected ErrProxyExists, got %v", err)
+	}
+
+	checks := []models.CheckedProxy{
+		{Proxy: a, Result: models.IPQualityResult{Status: "Live", IP: "9.9.9.9", Country: "US", LatencyMS: 120}},
+		{Proxy: models.ProxyInput{Host: "3.3.3.3", Port: "80"}, Result: models.IPQualityResult{Status: "Live"}},
+	}
+	for range 3 {
+		if n, err := inv.RecordChecks(checks); err != nil || n != 1 {
+			t.Fatalf("RecordChecks = %d, %v", n, err)
+		}
+	}
+	if _, err := inv.RecordChecks([]models.CheckedProxy{{Proxy: a, Result: models.IPQualityResult{Status: "Dead", Error: "timeout"}}}); err != nil {
+		t.Fatal(err)
+	}
+	history, err := inv.History(p.ID)
+	if err != nil || len(history) != 2 {
+		t.Fatalf("History = %+v, %v", history, err)
+	}
+	if history[0].Status != "Dead" || history[1].IP != "9.9.9.9" {
+		t.Errorf("History not newest first: %+v", history)
+	}
+	p, err = inv.Get(p.ID)
+	if err != nil || p.Status != "Dead" || p.LastResult == nil || p.LastResult.Error != "timeout" || p.LastChecked == nil {
+		t.Errorf("Last result not stored: %+v, %v", p, err)
+	}
+	if list, _, _ := inv.List(InventoryFilter{Status: "dead"}); len(list) != 1 {
+		t.Errorf("List by status = %+v", list)
+	}
+
+	if _, err := inv.Update(p.ID, "other", "", []string{"mobile"}); err != nil {
+		t.Fatal(err)
+	}
+	if list, total, _ := inv.List(InventoryFilter{Supplier: "other", Tag: "mobile"}); total != 1 || len(list) != 1 {
+		t.Errorf("Update not applied: %+v", list)
+	}
+	if err := inv.Delete(p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inv.History(p.ID); !errors.Is(err, ErrProxyNotFound) {
+		t.Errorf("Expected ErrProxyNotFound after delete, got %v", err)
+	}
+	if list, total, _ := inv.List(InventoryFilter{Limit: 10}); total != 1 || list[0].Proxy.Host != "2.2.2.2" {
+		t.Errorf("Unexpected inventory after delete: %+v", list)
+	}
+}
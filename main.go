@@ -28,16 +28,55 @@ func main() {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-API-Key", "X-User"},
+		ExposedHeaders:   []string{"X-Job-ID"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 
 	r.Route("/api", func(r chi.Router) {
+		r.Get("/health", app.HandleHealth)
 		r.Post("/parse", app.HandleParseInput)
+		r.Post("/import", app.HandleImportFile)
 		r.Post("/check/whois", app.HandleCheckWhois)
 		r.Post("/check/quality", app.HandleCheckIPQuality)
+		r.Get("/jobs", app.HandleJobs)
+		r.Get("/jobs/{id}/export", app.HandleExportJob)
+		r.Get("/pac", app.HandlePAC)
+		r.Post("/pac", app.HandlePAC)
+		r.Get("/gateway", app.HandleGatewayStatus)
+		r.Route("/pool", func(r chi.Router) {
+			r.Get("/", app.HandlePoolStatus)
+			r.Post("/lease", app.HandleLease)
+			r.Post("/leases/{id}/release", app.HandleReleaseLease)
+			r.Post("/leases/{id}/bad", app.HandleReportBadLease)
+		})
+		r.Route("/inventory", func(r chi.Router) {
+			r.Get("/", app.HandleInventory)
+			r.Post("/", app.HandleAddInventoryProxy)
+			r.Post("/import", app.HandleImportInventory)
+			r.Get("/{id}", app.HandleInventoryProxy)
+			r.Put("/{id}", app.HandleUpdateInventoryProxy)
+			r.Delete("/{id}", app.HandleDeleteInventoryProxy)
+			r.Get("/{id}/history", app.HandleInventoryHistory)
+		})
+		r.Route("/subscriptions", func(r chi.Router) {
+			r.Get("/", app.HandleSubscriptions)
+			r.Post("/", app.HandleAddSubscription)
+			r.Delete("/{name}", app.HandleDeleteSubscription)
+			r.Post("/{name}/refresh", app.HandleRefreshSubscription)
+		})
 		r.Post("/config/ipquality/apikey", app.HandleSetAPIKey)
+		r.Post("/classifier/import/{kind}", app.HandleImportClassifierData)
+
+		r.Get("/admin/lookups", app.HandleLookupStats)
+		r.Get("/admin/ratelimits", app.HandleRateLimits)
+		r.Get("/admin/scheduler", app.HandleSchedulerStatus)
+		r.Route("/admin/cache", func(r chi.Router) {
+			r.Get("/stats", app.HandleCacheStats)
+			r.Delete("/", app.HandleCachePurge)
+			r.Post("/vacuum", app.HandleCacheVacuum)
+		})
 	})
 
 	// Serve Static Files